@@ -32,13 +32,17 @@ type Command struct {
 }
 
 var commands = map[string]*Command{
+	"config":  &ConfigCommand,
 	"copy":    &CopyCommand,
 	"delete":  &DeleteCommand,
 	"dupes":   &DupesCommand,
 	"files":   &FilesCommand,
 	"help":    &HelpCommand,
+	"imply":   &ImplyCommand,
+	"init":    &InitCommand,
 	"merge":   &MergeCommand,
 	"mount":   &MountCommand,
+	"queries": &QueriesCommand,
 	"rename":  &RenameCommand,
 	"repair":  &RepairCommand,
 	"stats":   &StatsCommand,
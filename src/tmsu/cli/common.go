@@ -21,6 +21,8 @@ import (
 	"errors"
 	"os"
 	"syscall"
+	"tmsu/common/log"
+	"tmsu/storage"
 	"unsafe"
 )
 
@@ -31,6 +33,25 @@ type TagValuePair struct {
 	ValueId uint
 }
 
+// Commits the changes made unless there were errors, in which case the changes
+// are left to be rolled back unless the user opted to continue on error.
+func commitChanges(store *storage.Storage, wereErrors, continueOnError bool) error {
+	if wereErrors && !continueOnError {
+		log.Warn("no changes were made.")
+		return blankError
+	}
+
+	if err := store.Commit(); err != nil {
+		return err
+	}
+
+	if wereErrors {
+		return blankError
+	}
+
+	return nil
+}
+
 func terminalWidth() int {
 	var s winsize
 
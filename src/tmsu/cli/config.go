@@ -0,0 +1,303 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"tmsu/common/log"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+var ConfigCommand = Command{
+	Name:     "config",
+	Synopsis: "Lists or changes database and tag settings",
+	Description: `tmsu config [NAME[=VALUE]]...
+tmsu config tag TAG [NAME[=VALUE]]...
+
+Lists or changes the database settings or, in the second form, the settings of
+a tag.
+
+When run without arguments all settings are listed along with their current
+values. Where NAME is specified the value of that setting is shown. Where
+NAME=VALUE is specified the setting is changed.
+
+Settings:
+
+    fingerprintAlgorithm    the algorithm used to fingerprint files (default:
+                            dynamic:SHA256). One of: dynamic:SHA256,
+                            dynamic:SHA1, dynamic:MD5, SHA256, SHA1, MD5,
+                            symlinkTargetName, symlinkTargetNameNoExt.
+    journalMode             the SQLite journal mode (default: delete). One of:
+                            delete, wal. Write-ahead logging lets readers, such
+                            as the virtual filesystem, carry on whilst another
+                            process makes changes. Takes effect the next time
+                            the database is opened.
+
+Tag settings:
+
+    type                    the type of the tag's values (default: auto). One
+                            of: auto, int, decimal, date, text. Values applied
+                            with the tag must be of this type and are compared
+                            accordingly in queries. Dates may be partial, e.g.
+                            '2014' or '2014-06'. With 'auto', values are
+                            compared as numbers where the query value is a
+                            number and as text otherwise.
+
+Changing the fingerprint algorithm does not affect the fingerprints already
+stored in the database: use 'tmsu repair --refingerprint' to recalculate them.
+
+Examples:
+
+    $ tmsu config
+    fingerprintAlgorithm=dynamic:SHA256
+    journalMode=delete
+    $ tmsu config fingerprintAlgorithm
+    dynamic:SHA256
+    $ tmsu config fingerprintAlgorithm=MD5
+    $ tmsu config tag year type=int
+    $ tmsu config tag year
+    type=int`,
+	Options: Options{},
+	Exec:    configExec,
+}
+
+func configExec(options Options, args []string) error {
+	store, err := storage.Open()
+	if err != nil {
+		return fmt.Errorf("could not open storage: %v", err)
+	}
+	defer store.Close()
+
+	if len(args) == 0 {
+		return listAllSettings(store)
+	}
+
+	if args[0] == "tag" {
+		if len(args) < 2 {
+			return fmt.Errorf("tag to configure must be specified.")
+		}
+
+		return configTag(store, args[1], args[2:])
+	}
+
+	// the write lock is only needed to change settings
+	changes := changesSettings(args)
+	if changes {
+		if err := store.Begin(); err != nil {
+			return err
+		}
+		defer store.Rollback()
+	}
+
+	wereErrors := false
+	for _, arg := range args {
+		var err error
+
+		if index := strings.Index(arg, "="); index != -1 {
+			err = updateSetting(store, arg[:index], arg[index+1:])
+		} else {
+			err = printSetting(store, arg, len(args) > 1)
+		}
+
+		if err != nil {
+			log.Warn(err.Error())
+			wereErrors = true
+		}
+	}
+
+	return finishConfig(store, changes, wereErrors)
+}
+
+// unexported
+
+func configTag(store *storage.Storage, tagName string, args []string) error {
+	var tag *entities.Tag
+	var err error
+
+	changes := changesSettings(args)
+	if changes {
+		if err := store.Begin(); err != nil {
+			return err
+		}
+		defer store.Rollback()
+
+		tag, err = getOrCreateTag(store, tagName)
+		if err != nil {
+			return err
+		}
+	} else {
+		tag, err = store.TagByName(tagName)
+		if err != nil {
+			return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err)
+		}
+		if tag == nil {
+			return fmt.Errorf("no such tag '%v'.", tagName)
+		}
+	}
+
+	if len(args) == 0 {
+		return listTagSettings(store, tag)
+	}
+
+	wereErrors := false
+	for _, arg := range args {
+		var err error
+
+		if index := strings.Index(arg, "="); index != -1 {
+			err = updateTagSetting(store, tag, arg[:index], arg[index+1:])
+		} else {
+			err = printTagSetting(store, tag, arg, len(args) > 1)
+		}
+
+		if err != nil {
+			log.Warn(err.Error())
+			wereErrors = true
+		}
+	}
+
+	return finishConfig(store, changes, wereErrors)
+}
+
+// Commits the changes, if settings were changed, or else reports whether there
+// were errors reading them.
+func finishConfig(store *storage.Storage, changes, wereErrors bool) error {
+	if changes {
+		return commitChanges(store, wereErrors, false)
+	}
+
+	if wereErrors {
+		return blankError
+	}
+
+	return nil
+}
+
+// Determines whether any of the arguments change a setting.
+func changesSettings(args []string) bool {
+	for _, arg := range args {
+		if strings.Contains(arg, "=") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func listAllSettings(store *storage.Storage) error {
+	log.Info(2, "retrieving settings.")
+
+	settings, err := store.Settings()
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err)
+	}
+
+	for _, setting := range settings {
+		fmt.Printf("%v=%v\n", setting.Name, setting.Value)
+	}
+
+	return nil
+}
+
+func printSetting(store *storage.Storage, name string, showName bool) error {
+	setting, err := store.Setting(name)
+	if err != nil {
+		return fmt.Errorf("could not retrieve setting '%v': %v", name, err)
+	}
+	if setting == nil {
+		return fmt.Errorf("no such setting '%v'.", name)
+	}
+
+	if showName {
+		fmt.Printf("%v=%v\n", setting.Name, setting.Value)
+	} else {
+		fmt.Println(setting.Value)
+	}
+
+	return nil
+}
+
+func updateSetting(store *storage.Storage, name, value string) error {
+	oldSetting, err := store.Setting(name)
+	if err != nil {
+		return fmt.Errorf("could not retrieve setting '%v': %v", name, err)
+	}
+
+	log.Infof(2, "updating setting '%v' to '%v'.", name, value)
+
+	if _, err := store.UpdateSetting(name, value); err != nil {
+		return fmt.Errorf("could not update setting '%v': %v", name, err)
+	}
+
+	if name == "fingerprintAlgorithm" && oldSetting != nil && oldSetting.Value != value {
+		fileCount, err := store.FileCount()
+		if err != nil {
+			return fmt.Errorf("could not retrieve file count: %v", err)
+		}
+
+		if fileCount > 0 {
+			log.Warnf("the %v files in the database were fingerprinted using '%v': run 'tmsu repair --refingerprint' to recalculate their fingerprints.", fileCount, oldSetting.Value)
+		}
+	}
+
+	return nil
+}
+
+func listTagSettings(store *storage.Storage, tag *entities.Tag) error {
+	log.Infof(2, "retrieving settings for tag '%v'.", tag.Name)
+
+	settings, err := store.TagSettings(tag.Id)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings for tag '%v': %v", tag.Name, err)
+	}
+
+	for _, setting := range settings {
+		fmt.Printf("%v=%v\n", setting.Name, setting.Value)
+	}
+
+	return nil
+}
+
+func printTagSetting(store *storage.Storage, tag *entities.Tag, name string, showName bool) error {
+	setting, err := store.TagSetting(tag.Id, name)
+	if err != nil {
+		return fmt.Errorf("could not retrieve setting '%v' for tag '%v': %v", name, tag.Name, err)
+	}
+	if setting == nil {
+		return fmt.Errorf("no such tag setting '%v'.", name)
+	}
+
+	if showName {
+		fmt.Printf("%v=%v\n", setting.Name, setting.Value)
+	} else {
+		fmt.Println(setting.Value)
+	}
+
+	return nil
+}
+
+func updateTagSetting(store *storage.Storage, tag *entities.Tag, name, value string) error {
+	log.Infof(2, "updating setting '%v' for tag '%v' to '%v'.", name, tag.Name, value)
+
+	if _, err := store.UpdateTagSetting(tag.Id, name, value); err != nil {
+		return fmt.Errorf("could not update setting '%v' for tag '%v': %v", name, tag.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,333 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/storage"
+	"tmsu/storage/database"
+)
+
+func TestConfigListDefaults(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	// test
+
+	if err := ConfigCommand.Exec(Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "fingerprintAlgorithm=dynamic:SHA256\njournalMode=delete\n", string(bytes))
+}
+
+func TestConfigSet(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	// test
+
+	if err := ConfigCommand.Exec(Options{}, []string{"fingerprintAlgorithm=MD5"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	setting, err := store.Setting("fingerprintAlgorithm")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if setting.Value != "MD5" {
+		test.Fatalf("Expected setting value 'MD5' but was '%v'.", setting.Value)
+	}
+}
+
+func TestConfigGet(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("fingerprintAlgorithm", "SHA1"); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := ConfigCommand.Exec(Options{}, []string{"fingerprintAlgorithm"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "SHA1\n", string(bytes))
+}
+
+func TestConfigGetWhileLocked(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	defer func(timeout time.Duration) { database.BusyTimeout = timeout }(database.BusyTimeout)
+	database.BusyTimeout = 100 * time.Millisecond
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("fingerprintAlgorithm", "SHA1"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddTag("year"); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+	defer store.Rollback()
+
+	// test
+
+	if err := ConfigCommand.Exec(Options{}, []string{"fingerprintAlgorithm"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := ConfigCommand.Exec(Options{}, []string{"tag", "year", "type"}); err != nil {
+		test.Fatal(err)
+	}
+	if _, ok := ConfigCommand.Exec(Options{}, []string{"fingerprintAlgorithm=MD5"}).(database.LockedError); !ok {
+		test.Fatal("Setting was changed whilst the database was locked.")
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "SHA1\nauto\n", string(bytes))
+}
+
+func TestConfigSetInvalid(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	// test
+
+	if err := ConfigCommand.Exec(Options{}, []string{"fingerprintAlgorithm=CRC32"}); err == nil {
+		test.Fatal("Invalid fingerprint algorithm was accepted.")
+	}
+
+	if err := ConfigCommand.Exec(Options{}, []string{"noSuchSetting=1"}); err == nil {
+		test.Fatal("Unknown setting was accepted.")
+	}
+
+	// validate
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	setting, err := store.Setting("fingerprintAlgorithm")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if setting.Value != "dynamic:SHA256" {
+		test.Fatalf("Expected setting value 'dynamic:SHA256' but was '%v'.", setting.Value)
+	}
+}
+
+func TestConfigTagType(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	// test
+
+	if err := ConfigCommand.Exec(Options{}, []string{"tag", "year", "type=int"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := ConfigCommand.Exec(Options{}, []string{"tag", "year"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := ConfigCommand.Exec(Options{}, []string{"tag", "year", "type=complex"}); err == nil {
+		test.Fatal("Invalid value type was accepted.")
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "type=int\n", string(bytes))
+}
+
+func TestConfigTagTypeExistingValues(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	file, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	tag, err := store.AddTag("year")
+	if err != nil {
+		test.Fatal(err)
+	}
+	value, err := store.AddValue("unknown")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(file.Id, tag.Id, value.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := ConfigCommand.Exec(Options{}, []string{"tag", "year", "type=int"}); err == nil {
+		test.Fatal("Value type was accepted despite existing values of another type.")
+	}
+
+	// validate
+
+	setting, err := store.TagSetting(tag.Id, "type")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if setting.Value != "auto" {
+		test.Fatalf("Expected value type 'auto' but was '%v'.", setting.Value)
+	}
+}
+
+func TestConfigJournalModeWal(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+	defer os.Remove(databasePath + "-wal")
+	defer os.Remove(databasePath + "-shm")
+
+	if err := ConfigCommand.Exec(Options{}, []string{"journalMode=wal"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddTag("apple"); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if _, err := os.Stat(databasePath + "-wal"); err != nil {
+		test.Fatalf("Expected write-ahead log to exist: %v", err)
+	}
+}
@@ -34,17 +34,23 @@ Examples:
 
     $ tmsu copy cheese wine
     $ tmsu copy report document`,
-	Options: Options{},
+	Options: Options{{"--continue-on-error", "-C", "apply the successful changes despite errors", false, ""}},
 	Exec:    copyExec,
 }
 
 func copyExec(options Options, args []string) error {
+	continueOnError := options.HasOption("--continue-on-error")
+
 	store, err := storage.Open()
 	if err != nil {
 		return fmt.Errorf("could not open storage: %v", err)
 	}
 	defer store.Close()
-	defer store.Commit()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
 
 	sourceTagName := args[0]
 	destTagNames := args[1:]
@@ -76,9 +82,5 @@ func copyExec(options Options, args []string) error {
 		}
 	}
 
-	if wereErrors {
-		return blankError
-	}
-
-	return nil
+	return commitChanges(store, wereErrors, continueOnError)
 }
@@ -30,11 +30,14 @@ var DeleteCommand = Command{
 
 Permanently deletes the TAGs specified.
 
+A tag that is used by a saved query cannot be deleted: delete or change the
+query first using the 'queries' command.
+
 Examples:
 
     $ tmsu delete pineapple
     $ tmsu delete red green blue`,
-	Options: Options{},
+	Options: Options{{"--continue-on-error", "-C", "apply the successful changes despite errors", false, ""}},
 	Exec:    deleteExec,
 }
 
@@ -43,12 +46,18 @@ func deleteExec(options Options, args []string) error {
 		return fmt.Errorf("no tags to delete specified.")
 	}
 
+	continueOnError := options.HasOption("--continue-on-error")
+
 	store, err := storage.Open()
 	if err != nil {
 		return fmt.Errorf("could not open storage: %v", err)
 	}
 	defer store.Close()
-	defer store.Commit()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
 
 	wereErrors := false
 	for _, tagName := range args {
@@ -68,9 +77,5 @@ func deleteExec(options Options, args []string) error {
 		}
 	}
 
-	if wereErrors {
-		return blankError
-	}
-
-	return nil
+	return commitChanges(store, wereErrors, continueOnError)
 }
@@ -142,3 +142,44 @@ func TestDeleteNonExistentTag(test *testing.T) {
 		test.Fatal("Non-existent from tag was not identified.")
 	}
 }
+
+func TestDeleteTagUsedBySavedQuery(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddTag("apple"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddTag("banana"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddQuery("apple or cherry", ""); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := DeleteCommand.Exec(Options{}, []string{"apple", "banana"}); err == nil {
+		test.Fatal("Tag used by saved query was deleted.")
+	}
+
+	// validate
+
+	tags, err := store.Tags()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(tags) != 2 {
+		test.Fatalf("Expected no tags to be deleted but there are %v.", len(tags))
+	}
+}
@@ -20,6 +20,7 @@ package cli
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"tmsu/common/format"
 	"tmsu/common/log"
@@ -27,6 +28,7 @@ import (
 	"tmsu/entities"
 	"tmsu/query"
 	"tmsu/storage"
+	"tmsu/storage/database"
 )
 
 var FilesCommand = Command{
@@ -38,17 +40,69 @@ Lists the files that match the QUERY specified.
 
 QUERY may contain:
 
-  * Tag names to match
+  * Tag names to match, which may contain the wildcards '*' and '?'
   * The logical operators: 'and', 'or' and 'not'
-  * The comparison operators: '=', '>', '<', '>=' and '<='
+  * The comparison operators: '=', '!=', '>', '<', '>=', '<=', '~' and '~='
   * Parentheses: '(' and ')'
+  * Named queries: '@NAME' (see the 'queries' command)
 
 The 'and' operator may be omitted for brevity, e.g. 'chalk cheese' is
 interpretted as 'chalk and cheese'.
 
+A tag name containing a wildcard matches files with any tag it matches: '*'
+matches any sequence of characters and '?' any single character, e.g.
+'genre-*' matches files tagged 'genre-rock' or 'genre-jazz'.
+
 The comparison operators are used to match on the values of tags. For example,
 'country=uk' will only match files tagged 'country=uk' whilst 'year<=2014'
-will match files tagged 'year=2014', 'year=2000', &c.
+will match files tagged 'year=2014', 'year=2000', &c. 'country!=uk' matches
+files tagged 'country' with any other value. '~' matches values against a
+wildcard pattern, where '*' matches any text and '?' any single character, e.g.
+'artist~The*', and '~=' is a case-insensitive equality, e.g. 'artist~=abba'.
+
+The '=' and '!=' operators also accept an inclusive range of values, e.g.
+'year=2000..2010', or a set of values, e.g. 'country=(fr,de,it)'.
+
+How values are compared depends upon the value type of the tag, which can be
+declared using 'tmsu config tag TAG type=TYPE'. For example, 'released=2014'
+matches all dates within 2014 where 'released' has the type 'date'.
+
+The comparison operators can also be used to match on the following file
+attributes:
+
+    size     the file size in bytes, or with a K, M, G or T suffix: 'size>100M'
+    mtime    the modification time, which may be partial: 'mtime<2014-06'
+    name     the file name: 'name=notes.txt' or, with wildcards, 'name~*.jpg'
+    dir      the file's directory: 'dir~/home/me/photos/*'
+    type     whether the item is a file or directory: 'type=dir'
+
+To compare the values of a tag that has the same name as a file attribute,
+precede the tag name with a backslash: '\size>3'.
+
+Names and values containing spaces, operators or other special characters can
+be quoted, e.g. 'title="Blue Train"' or "title='Blue Train'", or have the
+special characters escaped with a backslash, e.g. 'title=Blue\ Train'. Within
+quotes a backslash escapes the quote character or a backslash. The 'tags' and
+'values' commands quote values in the same way.
+
+The --explain option shows how a query is evaluated: the query after saved
+queries are expanded and it is simplified, with tags that do not exist folded
+away, along with the SQL used to retrieve the files. This is useful when
+reporting a problem with a query.
+
+The --sort option lists the files in order of name, path (the default), size,
+mtime (modification time), id (the order in which they were added) or tags
+(the number of tags applied) and --reverse reverses the order. --limit and
+--offset list a page of the files and --random lists a random sample of them,
+in random order unless --sort is also specified. These options apply to the
+count shown by --count, too, but cannot be combined with --top, --leaf or
+--recursive.
+
+The --rank option lists the files that match any of the query's terms, the
+operands of its 'and' operators, with the number of terms each matches. Files
+matching the most terms are listed first. The --min-score option omits files
+that match fewer terms. A term may be a tag, a value comparison or a
+parenthesised sub-query.
 
 Note: Your shell may try to interpret some of the punctuation, e.g. most shells
 will interpret the '<' and '>' operators as stream redirects. Enclosing the
@@ -63,7 +117,19 @@ Examples:
     $ tmsu files "music and (mp3 or flac)"
     $ tmsu files year=2014                # tagged 'year' with a value '2014'
     $ tmsu files "year<2014"              # tagged 'year' with values under '2014'
-    $ tmsu files year                     # tagged 'year' (any or no value)`,
+    $ tmsu files year                     # tagged 'year' (any or no value)
+    $ tmsu files "genre-*"                # tagged 'genre-rock', 'genre-jazz', &c.
+    $ tmsu files "country!=uk"            # tagged 'country' but not 'uk'
+    $ tmsu files "artist~The*"            # 'artist' values starting 'The'
+    $ tmsu files year=2000..2010          # 'year' values from 2000 to 2010
+    $ tmsu files "country=(fr,de,it)"     # 'country' values of 'fr', 'de' or 'it'
+    $ tmsu files 'title="Blue Train"'     # 'title' value containing a space
+    $ tmsu files "music and size>10M"     # tagged 'music' and over 10MiB
+    $ tmsu files "@holidays not blurry"   # the saved query 'holidays' refined
+    $ tmsu files --sort=size --reverse --limit=10 music
+    $ tmsu files --random=20 photo        # a random sample of 20 photos
+    $ tmsu files --rank beach sunset family "year>=2014"
+    $ tmsu files --rank --min-score=2 beach sunset family`,
 	Options: Options{{"--all", "-a", "list the complete set of tagged files", false, ""},
 		{"--directory", "-d", "list only items that are directories", false, ""},
 		{"--file", "-f", "list only items that are files", false, ""},
@@ -72,7 +138,15 @@ Examples:
 		{"--recursive", "-r", "read all files on the file-system under each matching directory, recursively", false, ""},
 		{"--print0", "-0", "delimit files with a NUL character rather than newline.", false, ""},
 		{"--count", "-c", "lists the number of files rather than their names", false, ""},
-		{"", "-1", "list one file per line", false, ""}},
+		{"", "-1", "list one file per line", false, ""},
+		{"--explain", "-e", "show the query as evaluated and the SQL used to retrieve the files rather than the files", false, ""},
+		{"--rank", "-k", "list files matching any of the query's terms, by the number of terms matched", false, ""},
+		{"--min-score", "-m", "with --rank, list only files matching at least this number of terms", true, ""},
+		{"--sort", "-s", "sort by name, path, size, mtime, id or tags (the number of tags)", true, ""},
+		{"--reverse", "-R", "list the files in reverse order", false, ""},
+		{"--limit", "-n", "list at most this number of files", true, ""},
+		{"--offset", "-o", "skip this number of files before listing them", true, ""},
+		{"--random", "-N", "list a random sample of this number of files", true, ""}},
 	Exec: filesExec,
 }
 
@@ -86,17 +160,141 @@ func filesExec(options Options, args []string) error {
 	showCount := options.HasOption("--count")
 	onePerLine := options.HasOption("-1")
 
+	selection, err := parseFileSelection(options)
+	if err != nil {
+		return err
+	}
+
+	isSelection := selection != database.FileSelection{}
+	if isSelection && !listsInOrder(topOnly, leafOnly, recursive) {
+		return fmt.Errorf("the --sort, --reverse, --limit, --offset and --random options cannot be combined with --top, --leaf or --recursive.")
+	}
+
 	if options.HasOption("--all") {
-		return listAllFiles(dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine)
+		return listAllFiles(selection, dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine)
 	}
 
 	queryText := strings.Join(args, " ")
-	return listFilesForQuery(queryText, dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine)
+
+	if options.HasOption("--explain") {
+		// the type is otherwise restricted once the files are retrieved
+		inOrder := listsInOrder(topOnly, leafOnly, recursive)
+		return explainQuery(queryText, selection, dirOnly && inOrder, fileOnly && inOrder)
+	}
+
+	if options.HasOption("--rank") {
+		if topOnly || leafOnly || recursive {
+			return fmt.Errorf("the --rank option cannot be combined with --top, --leaf or --recursive.")
+		}
+		if isSelection {
+			return fmt.Errorf("the --rank option cannot be combined with --sort, --reverse, --limit, --offset or --random.")
+		}
+
+		minimumScore := uint(1)
+		if options.HasOption("--min-score") {
+			argument := options.Get("--min-score").Argument
+			score, err := strconv.ParseUint(argument, 10, 0)
+			if err != nil || score == 0 {
+				return fmt.Errorf("invalid minimum score '%v': must be a positive number.", argument)
+			}
+
+			minimumScore = uint(score)
+		}
+
+		return listRankedFiles(queryText, minimumScore, dirOnly, fileOnly, print0, showCount)
+	}
+
+	return listFilesForQuery(queryText, selection, dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine)
 }
 
 // unexported
 
-func listAllFiles(dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine bool) error {
+func parseFileSelection(options Options) (database.FileSelection, error) {
+	var selection database.FileSelection
+
+	if options.HasOption("--sort") {
+		selection.Sort = options.Get("--sort").Argument
+		switch selection.Sort {
+		case "name", "path", "size", "mtime", "id", "tags":
+		default:
+			return selection, fmt.Errorf("invalid sort '%v': expected name, path, size, mtime, id or tags.", selection.Sort)
+		}
+	}
+
+	selection.Reverse = options.HasOption("--reverse")
+
+	var err error
+	if selection.Limit, err = parseCountOption(options, "--limit"); err != nil {
+		return selection, err
+	}
+	if selection.Offset, err = parseCountOption(options, "--offset"); err != nil {
+		return selection, err
+	}
+	if selection.Random, err = parseCountOption(options, "--random"); err != nil {
+		return selection, err
+	}
+
+	if options.HasOption("--random") {
+		if selection.Random == 0 {
+			return selection, fmt.Errorf("the --random option requires a positive number of files.")
+		}
+		if options.HasOption("--limit") || options.HasOption("--offset") {
+			return selection, fmt.Errorf("the --random option cannot be combined with --limit or --offset.")
+		}
+		if selection.Reverse && selection.Sort == "" {
+			// a random sample has no order to reverse
+			return selection, fmt.Errorf("the --random option cannot be combined with --reverse unless --sort is also specified.")
+		}
+	}
+
+	return selection, nil
+}
+
+func parseCountOption(options Options, name string) (uint, error) {
+	if !options.HasOption(name) {
+		return 0, nil
+	}
+
+	argument := options.Get(name).Argument
+	count, err := strconv.ParseUint(argument, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v '%v': must be a number.", strings.TrimPrefix(name, "--"), argument)
+	}
+
+	return uint(count), nil
+}
+
+// Determines whether the files are listed in the order in which they are
+// retrieved, rather than after determining the top-level or leaf items or
+// reading the contents of directories.
+func listsInOrder(topOnly, leafOnly, recursive bool) bool {
+	return !topOnly && !leafOnly && !recursive
+}
+
+// Restricts the expression, which may be nil, to directories or to files. When
+// files are listed in order this is done by the query so that any limit applies
+// to the files listed.
+func restrictType(expression query.Expression, dirOnly, fileOnly bool) query.Expression {
+	for _, restriction := range []struct {
+		apply bool
+		value string
+	}{{dirOnly, "dir"}, {fileOnly, "file"}} {
+		if !restriction.apply {
+			continue
+		}
+
+		var typeExpression query.Expression = query.AttributeExpression{"type", "=", query.ValueExpression{restriction.value}}
+		if expression == nil {
+			expression = typeExpression
+		} else {
+			expression = query.AndExpression{expression, typeExpression}
+		}
+	}
+
+	return expression
+}
+
+func listAllFiles(selection database.FileSelection, dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine bool) error {
 	store, err := storage.Open()
 	if err != nil {
 		return fmt.Errorf("could not open storage: %v", err)
@@ -105,15 +303,29 @@ func listAllFiles(dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showC
 
 	log.Info(2, "retrieving all files from database.")
 
-	files, err := store.Files()
-	if err != nil {
-		return fmt.Errorf("could not retrieve files: %v", err)
+	var expression query.Expression
+	if listsInOrder(topOnly, leafOnly, recursive) {
+		expression = restrictType(nil, dirOnly, fileOnly)
+	}
+
+	forEachFile := func(fn func(*entities.File) error) error {
+		var err error
+		if expression == nil {
+			err = store.ForEachFile(selection, fn)
+		} else {
+			err = store.ForEachQueryFile(expression, selection, fn)
+		}
+		if err != nil {
+			return fmt.Errorf("could not retrieve files: %v", err)
+		}
+
+		return nil
 	}
 
-	return listFiles(files, dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine)
+	return listFiles(forEachFile, dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine)
 }
 
-func listFilesForQuery(queryText string, dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine bool) error {
+func listFilesForQuery(queryText string, selection database.FileSelection, dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine bool) error {
 	if queryText == "" {
 		return fmt.Errorf("query must be specified. Use --all to show all files.")
 	}
@@ -131,42 +343,148 @@ func listFilesForQuery(queryText string, dirOnly, fileOnly, topOnly, leafOnly, r
 		return err
 	}
 
-	log.Info(2, "checking tag names")
+	expression, err = store.ExpandMacros(expression)
+	if err != nil {
+		return err
+	}
 
-	wereErrors := false
+	if err := checkTagNames(store, expression); err != nil {
+		return err
+	}
 
-	tagNames := query.TagNames(expression)
-	tags, err := store.TagsByNames(tagNames)
-	for _, tagName := range tagNames {
-		if !tags.ContainsName(tagName) {
-			log.Warnf("no such tag '%v'.", tagName)
-			wereErrors = true
-			continue
+	if listsInOrder(topOnly, leafOnly, recursive) {
+		expression = restrictType(expression, dirOnly, fileOnly)
+	}
+
+	log.Info(2, "querying database")
+
+	forEachFile := func(fn func(*entities.File) error) error {
+		if err := store.ForEachQueryFile(expression, selection, fn); err != nil {
+			return fmt.Errorf("could not query files: %v", err)
 		}
+
+		return nil
 	}
 
-	if wereErrors {
-		return blankError
+	if err = listFiles(forEachFile, dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine); err != nil {
+		return err
 	}
 
-	log.Info(2, "querying database")
+	return nil
+}
 
-	files, err := store.QueryFiles(expression)
+func listRankedFiles(queryText string, minimumScore uint, dirOnly, fileOnly, print0, showCount bool) error {
+	if queryText == "" {
+		return fmt.Errorf("query must be specified.")
+	}
+
+	store, err := storage.Open()
 	if err != nil {
-		return fmt.Errorf("could not query files: %v", err)
+		return fmt.Errorf("could not open storage: %v", err)
 	}
+	defer store.Close()
 
-	if err = listFiles(files, dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine); err != nil {
+	log.Info(2, "parsing query")
+
+	expression, err := query.Parse(queryText)
+	if err != nil {
 		return err
 	}
 
+	// saved queries are expanded within their terms by the storage
+	expandedExpression, err := store.ExpandMacros(expression)
+	if err != nil {
+		return err
+	}
+
+	if err := checkTagNames(store, expandedExpression); err != nil {
+		return err
+	}
+
+	log.Info(2, "ranking files")
+
+	count := 0
+	err = store.ForEachRankedFile(expression, minimumScore, func(file *entities.File, score uint) error {
+		if (dirOnly && !file.IsDir) || (fileOnly && file.IsDir) {
+			return nil
+		}
+
+		count++
+
+		if !showCount {
+			relPath := path.Rel(file.Path())
+			if print0 {
+				fmt.Printf("%v\000", relPath)
+			} else {
+				fmt.Printf("%v: %v\n", relPath, score)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not rank files: %v", err)
+	}
+
+	if showCount {
+		fmt.Println(count)
+	}
+
 	return nil
 }
 
-func listFiles(files entities.Files, dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine bool) error {
+func explainQuery(queryText string, selection database.FileSelection, dirOnly, fileOnly bool) error {
+	if queryText == "" {
+		return fmt.Errorf("query must be specified.")
+	}
+
+	store, err := storage.Open()
+	if err != nil {
+		return fmt.Errorf("could not open storage: %v", err)
+	}
+	defer store.Close()
+
+	log.Info(2, "parsing query")
+
+	expression, err := query.Parse(queryText)
+	if err != nil {
+		return err
+	}
+
+	expression = restrictType(expression, dirOnly, fileOnly)
+
+	log.Info(2, "explaining query")
+
+	expression, sql, params, err := store.ExplainQuery(expression, selection)
+	if err != nil {
+		return fmt.Errorf("could not explain query: %v", err)
+	}
+
+	fmt.Printf("query: %v\n", expression)
+	fmt.Printf("sql:\n%v\n", sql)
+	fmt.Println("parameters:")
+	for index, param := range params {
+		fmt.Printf("    %v: %#v\n", index+1, param)
+	}
+
+	return nil
+}
+
+// Lists the files visited by forEachFile. Unless the complete set is needed, to
+// format the output into columns or to determine the top-level or leaf items,
+// each file is listed as soon as it is retrieved.
+func listFiles(forEachFile func(func(*entities.File) error) error, dirOnly, fileOnly, topOnly, leafOnly, recursive, print0, showCount, onePerLine bool) error {
+	if listsInOrder(topOnly, leafOnly, recursive) {
+		return listFilesInOrder(forEachFile, print0, showCount, onePerLine)
+	}
+
 	tree := path.NewTree()
-	for _, file := range files {
+	err := forEachFile(func(file *entities.File) error {
 		tree.Add(file.Path(), file.IsDir)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	if topOnly {
@@ -222,3 +540,92 @@ func listFiles(files entities.Files, dirOnly, fileOnly, topOnly, leafOnly, recur
 
 	return nil
 }
+
+// Lists, or counts, the files visited by forEachFile in the order they are
+// retrieved. Other than when formatting into columns, each is listed as soon as
+// it is retrieved. Restricting the listing to directories or files is left to
+// the query.
+func listFilesInOrder(forEachFile func(func(*entities.File) error) error, print0, showCount, onePerLine bool) error {
+	columns := !showCount && !onePerLine && !print0
+
+	count := 0
+	relPaths := make([]string, 0, 10)
+	err := forEachFile(func(file *entities.File) error {
+		count++
+
+		relPath := path.Rel(file.Path())
+		switch {
+		case showCount:
+		case columns:
+			relPaths = append(relPaths, relPath)
+		case print0:
+			fmt.Printf("%v\000", relPath)
+		default:
+			fmt.Println(relPath)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if showCount {
+		fmt.Println(count)
+	} else if columns {
+		format.Columns(relPaths, terminalWidth())
+	}
+
+	return nil
+}
+
+// Checks that the tags named in the expression exist, warning of those that do
+// not along with any similarly named tags.
+func checkTagNames(store *storage.Storage, expression query.Expression) error {
+	log.Info(2, "checking tag names")
+
+	wereErrors := false
+
+	tagNames := query.TagNames(expression)
+	tags, err := store.TagsByNames(tagNames)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	for _, tagName := range tagNames {
+		if !tags.ContainsName(tagName) {
+			similarNames, err := store.SimilarTagNames(tagName)
+			if err != nil {
+				return fmt.Errorf("could not retrieve similar tags: %v", err)
+			}
+
+			if len(similarNames) == 0 {
+				log.Warnf("no such tag '%v'.", tagName)
+			} else {
+				log.Warnf("no such tag '%v': did you mean %v?", tagName, quoteAlternatives(similarNames))
+			}
+
+			wereErrors = true
+		}
+	}
+
+	if wereErrors {
+		return blankError
+	}
+
+	return nil
+}
+
+// Formats names as alternatives, e.g. "'a', 'b' or 'c'".
+func quoteAlternatives(names []string) string {
+	quoted := make([]string, len(names))
+	for index, name := range names {
+		quoted[index] = "'" + name + "'"
+	}
+
+	if len(quoted) == 1 {
+		return quoted[0]
+	}
+
+	return strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1]
+}
@@ -20,6 +20,7 @@ package cli
 import (
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"time"
 	"tmsu/common/fingerprint"
@@ -569,4 +570,1224 @@ func TestFilesOr(test *testing.T) {
 	compareOutput(test, "/tmp/b\n/tmp/b/a\n", string(bytes))
 }
 
+func TestFilesValueWithApostrophe(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagArtist, err := store.AddTag("artist")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	valueOBrien, err := store.AddValue("O'Brien")
+	if err != nil {
+		test.Fatal(err)
+	}
+	valueSmith, err := store.AddValue("Smith")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, tagArtist.Id, valueOBrien.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagArtist.Id, valueSmith.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := FilesCommand.Exec(Options{}, []string{"artist=O'Brien"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a\n", string(bytes))
+}
+
+func TestFilesValueOperators(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileC, err := store.AddFile("/tmp/c", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagArtist, err := store.AddTag("artist")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagCountry, err := store.AddTag("country")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	valueBeatles, err := store.AddValue("TheBeatles")
+	if err != nil {
+		test.Fatal(err)
+	}
+	valueKinks, err := store.AddValue("TheKinks")
+	if err != nil {
+		test.Fatal(err)
+	}
+	valueLowerBeatles, err := store.AddValue("thebeatles")
+	if err != nil {
+		test.Fatal(err)
+	}
+	valueUk, err := store.AddValue("uk")
+	if err != nil {
+		test.Fatal(err)
+	}
+	valueUs, err := store.AddValue("us")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, tagArtist.Id, valueBeatles.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileA.Id, tagCountry.Id, valueUk.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagArtist.Id, valueKinks.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagCountry.Id, valueUs.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileC.Id, tagArtist.Id, valueLowerBeatles.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	queries := []string{"artist~The*", "country!=uk", "artist~=THEBEATLES"}
+	for _, queryText := range queries {
+		if err := FilesCommand.Exec(Options{}, []string{queryText}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a\n/tmp/b\n/tmp/b\n/tmp/a\n/tmp/c\n", string(bytes))
+}
+
+func TestFilesValueRangesAndSets(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileC, err := store.AddFile("/tmp/c", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagYear, err := store.AddTag("year")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagCountry, err := store.AddTag("country")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	value2000, err := store.AddValue("2000")
+	if err != nil {
+		test.Fatal(err)
+	}
+	value2005, err := store.AddValue("2005")
+	if err != nil {
+		test.Fatal(err)
+	}
+	value2015, err := store.AddValue("2015")
+	if err != nil {
+		test.Fatal(err)
+	}
+	valueFr, err := store.AddValue("fr")
+	if err != nil {
+		test.Fatal(err)
+	}
+	valueDe, err := store.AddValue("de")
+	if err != nil {
+		test.Fatal(err)
+	}
+	valueUk, err := store.AddValue("uk")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, tagYear.Id, value2000.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileA.Id, tagCountry.Id, valueFr.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagYear.Id, value2005.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagCountry.Id, valueDe.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileC.Id, tagYear.Id, value2015.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileC.Id, tagCountry.Id, valueUk.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	queries := []string{"year=2000..2010", "year=999..2001", "year!=2000..2010", "country=(fr,uk)", "country!=(fr,de)"}
+	for _, queryText := range queries {
+		if err := FilesCommand.Exec(Options{}, []string{queryText}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a\n/tmp/b\n/tmp/a\n/tmp/c\n/tmp/a\n/tmp/c\n/tmp/c\n", string(bytes))
+}
+
+func TestFilesTypedValues(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileC, err := store.AddFile("/tmp/c", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagReleased, err := store.AddTag("released")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.UpdateTagSetting(tagReleased.Id, "type", "date"); err != nil {
+		test.Fatal(err)
+	}
+	tagCode, err := store.AddTag("code")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.UpdateTagSetting(tagCode.Id, "type", "text"); err != nil {
+		test.Fatal(err)
+	}
+
+	valueJune, err := store.AddValue("2014-06")
+	if err != nil {
+		test.Fatal(err)
+	}
+	value2013, err := store.AddValue("2013")
+	if err != nil {
+		test.Fatal(err)
+	}
+	valueMidJune, err := store.AddValue("2014-06-15")
+	if err != nil {
+		test.Fatal(err)
+	}
+	value10, err := store.AddValue("10")
+	if err != nil {
+		test.Fatal(err)
+	}
+	value9, err := store.AddValue("9")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, tagReleased.Id, valueJune.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileA.Id, tagCode.Id, value10.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagReleased.Id, value2013.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagCode.Id, value9.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileC.Id, tagReleased.Id, valueMidJune.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	queries := []string{"released=2014", "released<2014-06-10", "released=2013..2014-05", "code<2"}
+	for _, queryText := range queries {
+		if err := FilesCommand.Exec(Options{}, []string{queryText}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	if err := FilesCommand.Exec(Options{}, []string{"released=June"}); err == nil {
+		test.Fatal("Invalid date was accepted.")
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a\n/tmp/c\n/tmp/a\n/tmp/b\n/tmp/b\n/tmp/a\n", string(bytes))
+}
+
+func TestFilesTagPattern(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileC, err := store.AddFile("/tmp/c", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileD, err := store.AddFile("/tmp/d", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagRock, err := store.AddTag("genre-rock")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagJazz, err := store.AddTag("genre-jazz")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagPunk, err := store.AddTag("genre-punk")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagNikon, err := store.AddTag("camera-nikon")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagSexPistols, err := store.AddTag("sex-pistols")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagMusic, err := store.AddTag("music")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagLive, err := store.AddTag("genre-[live]")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.AddImplication(tagSexPistols.Id, tagPunk.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, tagRock.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagJazz.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagNikon.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileC.Id, tagSexPistols.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileD.Id, tagMusic.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileD.Id, tagLive.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	queries := []string{"genre-*", "genre-* and not camera-*", "genre-?ock", "unknown-*", "genre-[*"}
+	for _, queryText := range queries {
+		if err := FilesCommand.Exec(Options{}, []string{queryText}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a\n/tmp/b\n/tmp/c\n/tmp/d\n/tmp/a\n/tmp/c\n/tmp/d\n/tmp/a\n/tmp/d\n", string(bytes))
+}
+
+func TestFilesExplain(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddTag("music"); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := FilesCommand.Exec(Options{Option{"--explain", "-e", "", false, ""}}, []string{"not not music and music or unknown"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	output := string(bytes)
+
+	if !strings.HasPrefix(output, "query: music\nsql:\n") {
+		test.Fatalf("Unexpected query explanation: %v", output)
+	}
+	if !strings.Contains(output, "FROM file") {
+		test.Fatalf("Explanation does not contain the SQL: %v", output)
+	}
+	if !strings.HasSuffix(output, "parameters:\n    1: \"music\"\n") {
+		test.Fatalf("Unexpected query parameters: %v", output)
+	}
+}
+
+func TestFilesExplainSelection(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	tagMusic, err := store.AddTag("music")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagJazz, err := store.AddTag("jazz")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.AddImplication(tagJazz.Id, tagMusic.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	options := Options{Option{"--explain", "-e", "", false, ""},
+		Option{"--file", "-f", "", false, ""},
+		Option{"--sort", "-s", "", true, "size"},
+		Option{"--limit", "-n", "", true, "5"}}
+	if err := FilesCommand.Exec(options, []string{"music"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	output := string(bytes)
+
+	if !strings.HasPrefix(output, "query: (music or jazz) and type=file\nsql:\n") {
+		test.Fatalf("Explanation does not show the query as evaluated: %v", output)
+	}
+	if !strings.Contains(output, "ORDER BY") || !strings.Contains(output, "LIMIT 5") {
+		test.Fatalf("Explanation does not show the file selection: %v", output)
+	}
+}
+
+func TestFilesUnknownTagSuggestions(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddTag("music"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddTag("photon"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddTag("photo"); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	err = redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	// test
+
+	if err := FilesCommand.Exec(Options{}, []string{"musik and photos"}); err != blankError {
+		test.Fatalf("Expected blank error but was: %v", err)
+	}
+
+	// validate
+
+	errFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(errFile)
+	compareOutput(test, "tmsu: no such tag 'musik': did you mean 'music'?\ntmsu: no such tag 'photos': did you mean 'photo' or 'photon'?\n", string(bytes))
+}
+
+func TestFilesParseErrorPosition(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	// test
+
+	err := FilesCommand.Exec(Options{}, []string{"music and (photo or"})
+
+	// validate
+
+	if err == nil {
+		test.Fatal("Invalid query was accepted.")
+	}
+
+	expected := "unexpected token: EOF.\n    music and (photo or\n                       ^"
+	if err.Error() != expected {
+		test.Fatalf("Expected error '%v' but was '%v'.", expected, err.Error())
+	}
+}
+
+func TestFilesTagConjunction(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileC, err := store.AddFile("/tmp/c", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagCommon, err := store.AddTag("common")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagRare, err := store.AddTag("rare")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagYear, err := store.AddTag("year")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	value2000, err := store.AddValue("2000")
+	if err != nil {
+		test.Fatal(err)
+	}
+	value2001, err := store.AddValue("2001")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, tagCommon.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileA.Id, tagRare.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileA.Id, tagYear.Id, value2000.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileA.Id, tagYear.Id, value2001.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagCommon.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagYear.Id, value2000.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileC.Id, tagCommon.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := FilesCommand.Exec(Options{}, []string{"common and year"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{}, []string{"common year rare common"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{Option{"--count", "-c", "", false, ""}}, []string{"year and common"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{}, []string{"common and missing"}); err == nil {
+		test.Fatal("Query for missing tag succeeded.")
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a\n/tmp/b\n/tmp/a\n2\n", string(bytes))
+}
+
+func TestFilesExplainOrdersBySelectivity(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagCommon, err := store.AddTag("common")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagRare, err := store.AddTag("rare")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, tagCommon.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileA.Id, tagRare.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagCommon.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := FilesCommand.Exec(Options{Option{"--explain", "-e", "", false, ""}}, []string{"type=file and common and rare"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{Option{"--explain", "-e", "", false, ""}}, []string{"common and rare"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	output := string(bytes)
+
+	if !strings.Contains(output, "parameters:\n    1: \"rare\"\n    2: \"common\"\nquery:") {
+		test.Fatalf("Operands were not ordered by selectivity: %v", output)
+	}
+	if !strings.Contains(output, "HAVING count(DISTINCT file_tag.tag_id) = 2") || !strings.HasSuffix(output, "parameters:\n    1: \"rare\"\n    2: \"common\"\n") {
+		test.Fatalf("Tag conjunction was not retrieved with a single join: %v", output)
+	}
+}
+
+func TestFilesStreamed(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, true)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileAB, err := store.AddFile("/tmp/a/b", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagMusic, err := store.AddTag("music")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileB.Id, tagMusic.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileA.Id, tagMusic.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileAB.Id, tagMusic.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := FilesCommand.Exec(Options{Option{"--all", "-a", "", false, ""}, Option{"", "-1", "", false, ""}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{Option{"", "-1", "", false, ""}}, []string{"music"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{Option{"--count", "-c", "", false, ""}, Option{"--file", "-f", "", false, ""}}, []string{"music"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{Option{"--print0", "-0", "", false, ""}, Option{"--directory", "-d", "", false, ""}}, []string{"music"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a\n/tmp/a/b\n/tmp/b\n/tmp/a\n/tmp/a/b\n/tmp/b\n2\n/tmp/a\000", string(bytes))
+}
+
+func TestFilesRank(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileC, err := store.AddFile("/tmp/c", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagBeach, err := store.AddTag("beach")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagSunset, err := store.AddTag("sunset")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagYear, err := store.AddTag("year")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	value2010, err := store.AddValue("2010")
+	if err != nil {
+		test.Fatal(err)
+	}
+	value2014, err := store.AddValue("2014")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, tagBeach.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileA.Id, tagSunset.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileA.Id, tagYear.Id, value2014.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagSunset.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagYear.Id, value2010.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileC.Id, tagBeach.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileC.Id, tagYear.Id, value2014.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	rank := Option{"--rank", "-k", "", false, ""}
+
+	if err := FilesCommand.Exec(Options{rank}, []string{"beach sunset year>=2014"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{rank, Option{"--min-score", "-m", "", true, "2"}}, []string{"beach", "sunset", "year>=2014"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{rank, Option{"--count", "-c", "", false, ""}}, []string{"(beach or sunset)", "not year"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{rank, Option{"--min-score", "-m", "", true, "0"}}, []string{"beach"}); err == nil {
+		test.Fatal("Zero minimum score was accepted.")
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a: 3\n/tmp/c: 2\n/tmp/b: 1\n/tmp/a: 3\n/tmp/c: 2\n3\n", string(bytes))
+}
+
+func TestFilesSortAndLimit(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 300, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("abc"), time.Now(), 100, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileC, err := store.AddFile("/tmp/c", fingerprint.Fingerprint("abc"), time.Now(), 200, true)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagX, err := store.AddTag("x")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagY, err := store.AddTag("y")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, tagX.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagX.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagY.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileC.Id, tagX.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	onePerLine := Option{"", "-1", "", false, ""}
+	count := Option{"--count", "-c", "", false, ""}
+
+	if err := FilesCommand.Exec(Options{onePerLine, Option{"--sort", "-s", "", true, "size"}}, []string{"x"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{onePerLine, Option{"--sort", "-s", "", true, "tags"}, Option{"--reverse", "-R", "", false, ""}}, []string{"x"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{onePerLine, Option{"--limit", "-n", "", true, "2"}, Option{"--offset", "-o", "", true, "1"}}, []string{"x"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{onePerLine, Option{"--file", "-f", "", false, ""}, Option{"--sort", "-s", "", true, "size"}, Option{"--limit", "-n", "", true, "1"}}, []string{"x"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{count, Option{"--limit", "-n", "", true, "2"}}, []string{"x"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{count, Option{"--all", "-a", "", false, ""}, Option{"--random", "-N", "", true, "2"}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(Options{Option{"--sort", "-s", "", true, "colour"}}, []string{"x"}); err == nil {
+		test.Fatal("Invalid sort was accepted.")
+	}
+	if err := FilesCommand.Exec(Options{Option{"--random", "-N", "", true, "2"}, Option{"--limit", "-n", "", true, "1"}}, []string{"x"}); err == nil {
+		test.Fatal("--random was combined with --limit.")
+	}
+	if err := FilesCommand.Exec(Options{Option{"--random", "-N", "", true, "2"}, Option{"--reverse", "-R", "", false, ""}}, []string{"x"}); err == nil {
+		test.Fatal("--random was combined with --reverse without --sort.")
+	}
+	if err := FilesCommand.Exec(Options{Option{"--top", "-t", "", false, ""}, Option{"--limit", "-n", "", true, "1"}}, []string{"x"}); err == nil {
+		test.Fatal("--limit was combined with --top.")
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/b\n/tmp/c\n/tmp/a\n/tmp/b\n/tmp/c\n/tmp/a\n/tmp/b\n/tmp/c\n/tmp/b\n2\n2\n", string(bytes))
+}
+
 //TODO tests for 'file' and 'directory' options.
+
+func TestFilesNamedQuery(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileD, err := store.AddFile("/tmp/d", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileBA, err := store.AddFile("/tmp/b/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("abc"), time.Now(), 123, true)
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagD, err := store.AddTag("d")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagB, err := store.AddTag("b")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagA, err := store.AddTag("a")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileD.Id, tagD.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, tagB.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileBA.Id, tagB.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileBA.Id, tagA.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddQuery("b or d", "bd"); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := FilesCommand.Exec(Options{}, []string{"@bd", "and", "not", "a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/b\n/tmp/d\n", string(bytes))
+}
+
+func TestFilesAttributes(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	old := time.Date(2010, 6, 15, 12, 0, 0, 0, time.Local)
+	recent := time.Date(2014, 6, 15, 12, 0, 0, 0, time.Local)
+
+	if _, err := store.AddFile("/tmp/photos/a.jpg", fingerprint.Fingerprint("abc"), old, 2<<20, false); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFile("/tmp/photos/b.jpg", fingerprint.Fingerprint("def"), recent, 100, false); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFile("/tmp/photos", fingerprint.Fingerprint(""), recent, 0, true); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFile("/tmp/notes.txt", fingerprint.Fingerprint("ghi"), recent, 2<<20, false); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	queries := []string{"size>1M", "name~*.jpg and mtime<2014", "type=dir", "dir~/tmp/* and mtime=2014-06-15", "not type=dir and size<=100"}
+	for _, queryText := range queries {
+		if err := FilesCommand.Exec(Options{Option{"", "-1", "", false, ""}}, []string{queryText}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/notes.txt\n/tmp/photos/a.jpg\n/tmp/photos/a.jpg\n/tmp/photos\n/tmp/photos/b.jpg\n/tmp/photos/b.jpg\n", string(bytes))
+}
@@ -22,6 +22,7 @@ import (
 	"math"
 	"sort"
 	"strconv"
+	"tmsu/storage/database"
 )
 
 var HelpCommand = Command{
@@ -85,6 +86,10 @@ func summary() {
 	for _, option := range globalOptions {
 		fmt.Printf("  %v, %v: %v\n", option.ShortName, option.LongName, option.Description)
 	}
+
+	fmt.Println()
+
+	fmt.Printf("Database: %v\n", database.Path)
 }
 
 func listCommands() {
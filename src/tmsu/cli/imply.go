@@ -0,0 +1,172 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+var ImplyCommand = Command{
+	Name:     "imply",
+	Synopsis: "Creates a tag implication",
+	Description: `tmsu imply [OPTION]... TAG IMPL...
+tmsu imply [OPTION]... --delete TAG IMPL...
+tmsu imply
+
+Creates a tag implication such that any file tagged TAG is also considered to be
+tagged IMPL. Implications are transitive: if 'mp3' implies 'music' and 'music'
+implies 'audio' then files tagged 'mp3' are matched by queries for 'audio'.
+
+Implied tags are not applied to the files themselves: they are taken into
+account when querying and are shown (in parentheses) by the 'tags' command.
+
+An implication that would result in a cycle, e.g. 'a' implies 'b' and 'b'
+implies 'a', is not permitted.
+
+When run without arguments lists the set of tag implications.
+
+Examples:
+
+    $ tmsu imply mp3 music
+    $ tmsu imply
+    mp3 -> music
+    $ tmsu imply --delete mp3 music`,
+	Options: Options{{"--delete", "-d", "deletes the tag implication", false, ""},
+		{"--continue-on-error", "-C", "apply the successful changes despite errors", false, ""}},
+	Exec: implyExec,
+}
+
+func implyExec(options Options, args []string) error {
+	switch len(args) {
+	case 0:
+		return listImplications()
+	case 1:
+		return fmt.Errorf("implied tags must be specified.")
+	}
+
+	continueOnError := options.HasOption("--continue-on-error")
+
+	if options.HasOption("--delete") {
+		return deleteImplications(args[0], args[1:], continueOnError)
+	}
+
+	return addImplications(args[0], args[1:], continueOnError)
+}
+
+// unexported
+
+func listImplications() error {
+	store, err := storage.Open()
+	if err != nil {
+		return fmt.Errorf("could not open storage: %v", err)
+	}
+	defer store.Close()
+
+	log.Info(2, "retrieving tag implications.")
+
+	implications, err := store.Implications()
+	if err != nil {
+		return fmt.Errorf("could not retrieve implications: %v", err)
+	}
+
+	for _, implication := range implications {
+		fmt.Printf("%v -> %v\n", implication.ImplyingTag.Name, implication.ImpliedTag.Name)
+	}
+
+	return nil
+}
+
+func addImplications(tagName string, impliedTagNames []string, continueOnError bool) error {
+	store, err := storage.Open()
+	if err != nil {
+		return fmt.Errorf("could not open storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
+
+	tag, err := getOrCreateTag(store, tagName)
+	if err != nil {
+		return err
+	}
+
+	wereErrors := false
+	for _, impliedTagName := range impliedTagNames {
+		impliedTag, err := getOrCreateTag(store, impliedTagName)
+		if err != nil {
+			return err
+		}
+
+		log.Infof(2, "adding implication '%v' -> '%v'.", tagName, impliedTagName)
+
+		if err := store.AddImplication(tag.Id, impliedTag.Id); err != nil {
+			log.Warnf("could not add implication '%v' -> '%v': %v", tagName, impliedTagName, err)
+			wereErrors = true
+		}
+	}
+
+	return commitChanges(store, wereErrors, continueOnError)
+}
+
+func deleteImplications(tagName string, impliedTagNames []string, continueOnError bool) error {
+	store, err := storage.Open()
+	if err != nil {
+		return fmt.Errorf("could not open storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
+
+	tag, err := store.TagByName(tagName)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err)
+	}
+	if tag == nil {
+		return fmt.Errorf("no such tag '%v'.", tagName)
+	}
+
+	wereErrors := false
+	for _, impliedTagName := range impliedTagNames {
+		impliedTag, err := store.TagByName(impliedTagName)
+		if err != nil {
+			return fmt.Errorf("could not retrieve tag '%v': %v", impliedTagName, err)
+		}
+		if impliedTag == nil {
+			log.Warnf("no such tag '%v'.", impliedTagName)
+			wereErrors = true
+			continue
+		}
+
+		log.Infof(2, "removing implication '%v' -> '%v'.", tagName, impliedTagName)
+
+		if err := store.RemoveImplication(tag.Id, impliedTag.Id); err != nil {
+			log.Warnf("could not remove implication '%v' -> '%v': %v", tagName, impliedTagName, err)
+			wereErrors = true
+		}
+	}
+
+	return commitChanges(store, wereErrors, continueOnError)
+}
@@ -0,0 +1,296 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/storage"
+)
+
+func TestImplyAdd(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	// test
+
+	if err := ImplyCommand.Exec(Options{}, []string{"mp3", "music", "audio"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	implications, err := store.Implications()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(implications) != 2 {
+		test.Fatalf("Expected 2 implications but were %v.", len(implications))
+	}
+	if implications[0].ImplyingTag.Name != "mp3" || implications[0].ImpliedTag.Name != "audio" {
+		test.Fatalf("Unexpected implication '%v' -> '%v'.", implications[0].ImplyingTag.Name, implications[0].ImpliedTag.Name)
+	}
+	if implications[1].ImplyingTag.Name != "mp3" || implications[1].ImpliedTag.Name != "music" {
+		test.Fatalf("Unexpected implication '%v' -> '%v'.", implications[1].ImplyingTag.Name, implications[1].ImpliedTag.Name)
+	}
+}
+
+func TestImplyList(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	mp3Tag, err := store.AddTag("mp3")
+	if err != nil {
+		test.Fatal(err)
+	}
+	musicTag, err := store.AddTag("music")
+	if err != nil {
+		test.Fatal(err)
+	}
+	catTag, err := store.AddTag("cat")
+	if err != nil {
+		test.Fatal(err)
+	}
+	animalTag, err := store.AddTag("animal")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.AddImplication(mp3Tag.Id, musicTag.Id); err != nil {
+		test.Fatal(err)
+	}
+	if err := store.AddImplication(catTag.Id, animalTag.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := ImplyCommand.Exec(Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "cat -> animal\nmp3 -> music\n", string(bytes))
+}
+
+func TestImplyCycle(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	aTag, err := store.AddTag("a")
+	if err != nil {
+		test.Fatal(err)
+	}
+	bTag, err := store.AddTag("b")
+	if err != nil {
+		test.Fatal(err)
+	}
+	cTag, err := store.AddTag("c")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.AddImplication(aTag.Id, bTag.Id); err != nil {
+		test.Fatal(err)
+	}
+	if err := store.AddImplication(bTag.Id, cTag.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	err = ImplyCommand.Exec(Options{}, []string{"c", "a"})
+
+	// validate
+
+	if err == nil {
+		test.Fatal("Cyclic implication was not identified.")
+	}
+
+	implications, err := store.Implications()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(implications) != 2 {
+		test.Fatalf("Expected 2 implications but were %v.", len(implications))
+	}
+}
+
+func TestImplyDelete(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	mp3Tag, err := store.AddTag("mp3")
+	if err != nil {
+		test.Fatal(err)
+	}
+	musicTag, err := store.AddTag("music")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.AddImplication(mp3Tag.Id, musicTag.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := ImplyCommand.Exec(Options{Option{"--delete", "-d", "", false, ""}}, []string{"mp3", "music"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	implications, err := store.Implications()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(implications) != 0 {
+		test.Fatalf("Expected no implications but were %v.", len(implications))
+	}
+}
+
+func TestImplyQuery(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	mp3Tag, err := store.AddTag("mp3")
+	if err != nil {
+		test.Fatal(err)
+	}
+	musicTag, err := store.AddTag("music")
+	if err != nil {
+		test.Fatal(err)
+	}
+	audioTag, err := store.AddTag("audio")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, mp3Tag.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, musicTag.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.AddImplication(mp3Tag.Id, musicTag.Id); err != nil {
+		test.Fatal(err)
+	}
+	if err := store.AddImplication(musicTag.Id, audioTag.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := FilesCommand.Exec(Options{}, []string{"audio"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := TagsCommand.Exec(Options{}, []string{"/tmp/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a\n/tmp/b\nmp3\n(audio)\n(music)\n", string(bytes))
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"tmsu/common/log"
+	"tmsu/storage/database"
+)
+
+var InitCommand = Command{
+	Name:     "init",
+	Synopsis: "Initializes a new local database",
+	Description: `tmsu init [PATH]
+
+Initializes a new local database at PATH/.tmsu/db or, where PATH is not
+specified, in the working directory.
+
+Unless a database is specified explicitly, with the --database global option or
+the TMSU_DB environment variable, TMSU uses the nearest local database found by
+searching the working directory and then each of its parent directories in
+turn. Where there is no local database the default database at
+$HOME/.tmsu/default.db is used.
+
+This allows each directory tree to carry its own tags. The paths of files within
+the directory tree are stored relative to it, so the tree can be moved, or
+mounted elsewhere, without needing to repair the database.
+
+Examples:
+
+    $ tmsu init
+    $ tmsu init /mnt/photos`,
+	Options: Options{},
+	Exec:    initExec,
+}
+
+func initExec(options Options, args []string) error {
+	var dir string
+	switch len(args) {
+	case 0:
+		workingDir, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("could not identify working directory: %v", err)
+		}
+		dir = workingDir
+	case 1:
+		dir = args[0]
+	default:
+		return fmt.Errorf("too many arguments.")
+	}
+
+	return initializeDatabase(dir)
+}
+
+// unexported
+
+func initializeDatabase(dir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", dir, err)
+	}
+
+	path := database.LocalPath(absDir)
+
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%v: database already exists.", path)
+	}
+
+	log.Infof(2, "creating directory '%v'.", filepath.Dir(path))
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModeDir|0755); err != nil {
+		return fmt.Errorf("%v: could not create directory: %v", filepath.Dir(path), err)
+	}
+
+	db, err := database.OpenAt(path)
+	if err != nil {
+		return fmt.Errorf("%v: could not create database: %v", path, err)
+	}
+
+	return db.Close()
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"tmsu/storage/database"
+)
+
+func TestInitCreatesLocalDatabase(test *testing.T) {
+	// set-up
+
+	dir := filepath.Join(os.TempDir(), "tmsu-init")
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "a", "b"), 0755); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := InitCommand.Exec(Options{}, []string{dir}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	expectedPath := filepath.Join(dir, ".tmsu", "db")
+
+	if _, err := os.Stat(expectedPath); err != nil {
+		test.Fatalf("Database was not created: %v", err)
+	}
+
+	path, err := database.FindLocal(filepath.Join(dir, "a", "b"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if path != expectedPath {
+		test.Fatalf("Expected local database '%v' but was '%v'.", expectedPath, path)
+	}
+}
+
+func TestInitExistingDatabase(test *testing.T) {
+	// set-up
+
+	dir := filepath.Join(os.TempDir(), "tmsu-init")
+	defer os.RemoveAll(dir)
+
+	if err := InitCommand.Exec(Options{}, []string{dir}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := InitCommand.Exec(Options{}, []string{dir}); err == nil {
+		test.Fatal("Database was initialized twice.")
+	}
+}
@@ -30,11 +30,15 @@ var MergeCommand = Command{
         
 Merges TAGs into tag DEST resulting in a single tag of name DEST.
 
+Implications and saved queries that use the TAGs are updated to use DEST
+instead. Implications that DEST would then have with itself, or that would
+create a cycle, are removed.
+
 Examples:
 
     $ tmsu merge cehese cheese
     $ tmsu merge outdoors outdoor outside`,
-	Options: Options{},
+	Options: Options{{"--continue-on-error", "-C", "apply the successful changes despite errors", false, ""}},
 	Exec:    mergeExec,
 }
 
@@ -43,12 +47,18 @@ func mergeExec(options Options, args []string) error {
 		return fmt.Errorf("too few arguments.")
 	}
 
+	continueOnError := options.HasOption("--continue-on-error")
+
 	store, err := storage.Open()
 	if err != nil {
 		return fmt.Errorf("could not open storage: %v", err)
 	}
 	defer store.Close()
-	defer store.Commit()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
 
 	destTagName := args[len(args)-1]
 	destTag, err := store.TagByName(destTagName)
@@ -64,6 +74,7 @@ func mergeExec(options Options, args []string) error {
 		if sourceTagName == destTagName {
 			log.Warnf("cannot merge tag '%v' into itself.", sourceTagName)
 			wereErrors = true
+			continue
 		}
 
 		sourceTag, err := store.TagByName(sourceTagName)
@@ -76,33 +87,12 @@ func mergeExec(options Options, args []string) error {
 			continue
 		}
 
-		log.Infof(2, "finding files tagged '%v'.", sourceTagName)
+		log.Infof(2, "merging tag '%v' into '%v'.", sourceTagName, destTagName)
 
-		fileTags, err := store.FileTagsByTagId(sourceTag.Id)
-		if err != nil {
-			return fmt.Errorf("could not retrieve files for tag '%v': %v", sourceTagName, err)
+		if err := store.MergeTag(sourceTag.Id, destTag.Id); err != nil {
+			return fmt.Errorf("could not merge tag '%v' into '%v': %v", sourceTagName, destTagName, err)
 		}
-
-		log.Infof(2, "applying tag '%v' to these files.", destTagName)
-
-		for _, fileTag := range fileTags {
-			_, err = store.AddFileTag(fileTag.FileId, destTag.Id, fileTag.ValueId)
-			if err != nil {
-				return fmt.Errorf("could not apply tag '%v' to file #%v: %v", destTagName, fileTag.FileId, err)
-			}
-		}
-
-		log.Infof(2, "deleting tag '%v'.", sourceTagName)
-
-		err = store.DeleteTag(sourceTag.Id)
-		if err != nil {
-			return fmt.Errorf("could not delete tag '%v': %v", sourceTagName, err)
-		}
-	}
-
-	if wereErrors {
-		return blankError
 	}
 
-	return nil
+	return commitChanges(store, wereErrors, continueOnError)
 }
@@ -19,9 +19,11 @@ package cli
 
 import (
 	"os"
+	"strings"
 	"testing"
 	"time"
 	"tmsu/common/fingerprint"
+	"tmsu/query"
 	"tmsu/storage"
 )
 
@@ -315,3 +317,201 @@ func TestMergeSourceAndDestinationTheSame(test *testing.T) {
 		test.Fatal("Expected source and destination the same tag to be identified.")
 	}
 }
+
+func TestMergeUpdatesSavedQueries(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddTag("cehese"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddTag("cheese"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddQuery("cehese and wine", ""); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddQuery("cehese", "dairy"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddQuery("cheese", ""); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := MergeCommand.Exec(Options{}, []string{"cehese", "cheese"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	queries, err := store.Queries()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(queries) != 2 {
+		test.Fatalf("Expected two saved queries but are %v.", len(queries))
+	}
+	if queries[0].Text != "cheese" || queries[0].Name != "dairy" {
+		test.Fatalf("Expected saved query '@dairy: cheese' but was '@%v: %v'.", queries[0].Name, queries[0].Text)
+	}
+	if queries[1].Text != "cheese and wine" {
+		test.Fatalf("Expected saved query 'cheese and wine' but was '%v'.", queries[1].Text)
+	}
+}
+
+func TestMergeMovesImplications(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	tagU, err := store.AddTag("u")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagW, err := store.AddTag("w")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagX, err := store.AddTag("x")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagY, err := store.AddTag("y")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagZ, err := store.AddTag("z")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// 'y -> x' would become 'x -> x', 'w -> y' already exists as 'w -> x' and
+	// 'y -> u' would complete the cycle 'x -> u -> x'
+	implications := [][2]uint{{tagY.Id, tagZ.Id}, {tagW.Id, tagY.Id}, {tagW.Id, tagX.Id}, {tagY.Id, tagU.Id}, {tagU.Id, tagX.Id}, {tagY.Id, tagX.Id}}
+	for _, implication := range implications {
+		if err := store.AddImplication(implication[0], implication[1]); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := MergeCommand.Exec(Options{}, []string{"y", "x"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	mergedImplications, err := store.Implications()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	described := make([]string, len(mergedImplications))
+	for index, implication := range mergedImplications {
+		described[index] = implication.ImplyingTag.Name + "->" + implication.ImpliedTag.Name
+	}
+
+	if strings.Join(described, " ") != "u->x w->x x->z" {
+		test.Fatalf("Expected implications 'u->x w->x x->z' but were '%v'.", strings.Join(described, " "))
+	}
+}
+
+func TestMergeUpdatesSavedQueriesAndImplications(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagCehese, err := store.AddTag("cehese")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddTag("cheese"); err != nil {
+		test.Fatal(err)
+	}
+	tagDairy, err := store.AddTag("dairy")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, tagCehese.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if err := store.AddImplication(tagCehese.Id, tagDairy.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddQuery("dairy and not cehese", "other-dairy"); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := MergeCommand.Exec(Options{}, []string{"cehese", "cheese"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	queries, err := store.Queries()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(queries) != 1 || queries[0].Text != "dairy and not cheese" {
+		test.Fatalf("Expected saved query 'dairy and not cheese' but were %v.", queries)
+	}
+
+	implications, err := store.Implications()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(implications) != 1 || implications[0].ImplyingTag.Name != "cheese" || implications[0].ImpliedTag.Name != "dairy" {
+		test.Fatalf("Expected implication 'cheese -> dairy' but were %v.", implications)
+	}
+
+	expression, err := query.Parse("@other-dairy or dairy")
+	if err != nil {
+		test.Fatal(err)
+	}
+	files, err := store.QueryFiles(expression)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Id != fileA.Id {
+		test.Fatalf("Expected '/tmp/a' to be implicitly tagged 'dairy' but files were %v.", files)
+	}
+}
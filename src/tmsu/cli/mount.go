@@ -43,8 +43,9 @@ Where FILE is specified, the database at FILE is mounted.
 If FILE is not specified but the TMSU_DB environment variable is defined then
 the database at TMSU_DB is mounted.
 
-Where neither FILE is specified nor TMSU_DB defined then the default database
-is mounted.
+Where neither FILE is specified nor TMSU_DB defined then the nearest local
+database (see the 'init' command) or, failing that, the default database is
+mounted.
 
 To allow other users access to the mounted filesystem, pass the 'allow_other'
 FUSE option, e.g. 'tmsu mount --option=allow_other mp'. (FUSE only allows the
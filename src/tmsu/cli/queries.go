@@ -0,0 +1,295 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"tmsu/common/log"
+	"tmsu/entities"
+	"tmsu/query"
+	"tmsu/storage"
+	"tmsu/storage/database"
+)
+
+var QueriesCommand = Command{
+	Name:     "queries",
+	Synopsis: "Manage saved queries",
+	Description: `tmsu queries
+tmsu queries --add [--name NAME] QUERY
+tmsu queries --run QUERY
+tmsu queries --rename OLD NEW
+tmsu queries --delete QUERY...
+
+Manages the saved queries. Saved queries appear as directories within the
+'queries' directory of the virtual filesystem.
+
+When run without arguments lists the saved queries.
+
+A query saved with a NAME can be referred to from other queries as '@NAME', e.g.
+'tmsu files "@holidays and not blurry"'. A query cannot refer to itself, either
+directly or via other queries, and a named query cannot be deleted whilst other
+queries refer to it. Where QUERY identifies a saved query it may be given as
+'@NAME'.
+
+Queries are checked for errors before they are saved. Queries containing spaces
+must be quoted when renaming or deleting.
+
+Examples:
+
+    $ tmsu queries --add "music and not mp3"
+    $ tmsu queries --add --name=holidays "beach or mountains"
+    $ tmsu queries
+    @holidays: beach or mountains
+    music and not mp3
+    $ tmsu queries --run music and not mp3
+    $ tmsu queries --run @holidays
+    $ tmsu queries --rename "music and not mp3" "music and flac"
+    $ tmsu queries --delete "music and flac"`,
+	Options: Options{{"--add", "-a", "saves the query", false, ""},
+		{"--name", "-n", "names the query so that it can be referred to from other queries", true, ""},
+		{"--run", "-r", "lists the files matching the saved query", false, ""},
+		{"--rename", "-m", "changes the text of a saved query", false, ""},
+		{"--delete", "-d", "deletes the saved queries", false, ""}},
+	Exec: queriesExec,
+}
+
+func queriesExec(options Options, args []string) error {
+	switch {
+	case options.HasOption("--add"):
+		name := ""
+		if options.HasOption("--name") {
+			name = options.Get("--name").Argument
+		}
+
+		return addQuery(strings.Join(args, " "), name)
+	case options.HasOption("--run"):
+		return runQuery(strings.Join(args, " "))
+	case options.HasOption("--rename"):
+		if len(args) != 2 {
+			return fmt.Errorf("query to rename and new query must both be specified.")
+		}
+
+		return renameQuery(args[0], args[1])
+	case options.HasOption("--delete"):
+		if len(args) == 0 {
+			return fmt.Errorf("queries to delete must be specified.")
+		}
+
+		return deleteQueries(args)
+	}
+
+	if len(args) > 0 {
+		return fmt.Errorf("too many arguments.")
+	}
+
+	return listQueries()
+}
+
+// unexported
+
+func listQueries() error {
+	store, err := storage.Open()
+	if err != nil {
+		return fmt.Errorf("could not open storage: %v", err)
+	}
+	defer store.Close()
+
+	log.Info(2, "retrieving saved queries.")
+
+	queries, err := store.Queries()
+	if err != nil {
+		return fmt.Errorf("could not retrieve queries: %v", err)
+	}
+
+	for _, savedQuery := range queries {
+		if savedQuery.Name != "" {
+			fmt.Printf("@%v: %v\n", savedQuery.Name, savedQuery.Text)
+		} else {
+			fmt.Println(savedQuery.Text)
+		}
+	}
+
+	return nil
+}
+
+func addQuery(text, name string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return fmt.Errorf("query must be specified.")
+	}
+
+	if _, err := query.Parse(text); err != nil {
+		return fmt.Errorf("invalid query '%v': %v", text, err)
+	}
+
+	store, err := storage.Open()
+	if err != nil {
+		return fmt.Errorf("could not open storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
+
+	existingQuery, err := store.Query(text)
+	if err != nil {
+		return fmt.Errorf("could not retrieve query '%v': %v", text, err)
+	}
+	if existingQuery != nil {
+		return fmt.Errorf("query '%v' is already saved.", text)
+	}
+
+	if name != "" {
+		namedQuery, err := store.QueryByName(name)
+		if err != nil {
+			return fmt.Errorf("could not retrieve query '@%v': %v", name, err)
+		}
+		if namedQuery != nil {
+			return fmt.Errorf("query '@%v' already exists.", name)
+		}
+	}
+
+	log.Infof(2, "saving query '%v'.", text)
+
+	if _, err := store.AddQuery(text, name); err != nil {
+		return fmt.Errorf("could not save query '%v': %v", text, err)
+	}
+
+	return store.Commit()
+}
+
+func runQuery(text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return fmt.Errorf("query must be specified.")
+	}
+
+	store, err := storage.Open()
+	if err != nil {
+		return fmt.Errorf("could not open storage: %v", err)
+	}
+
+	savedQuery, err := lookupQuery(store, text)
+	store.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return listFilesForQuery(savedQuery.Text, database.FileSelection{}, false, false, false, false, false, false, false, false)
+}
+
+func renameQuery(text, newText string) error {
+	newText = strings.TrimSpace(newText)
+	if newText == "" {
+		return fmt.Errorf("new query must be specified.")
+	}
+
+	if _, err := query.Parse(newText); err != nil {
+		return fmt.Errorf("invalid query '%v': %v", newText, err)
+	}
+
+	store, err := storage.Open()
+	if err != nil {
+		return fmt.Errorf("could not open storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
+
+	existingQuery, err := lookupQuery(store, text)
+	if err != nil {
+		return err
+	}
+
+	newQuery, err := store.Query(newText)
+	if err != nil {
+		return fmt.Errorf("could not retrieve query '%v': %v", newText, err)
+	}
+	if newQuery != nil {
+		return fmt.Errorf("query '%v' is already saved.", newText)
+	}
+
+	log.Infof(2, "renaming query '%v' to '%v'.", existingQuery.Text, newText)
+
+	if _, err := store.RenameQuery(existingQuery.Text, newText); err != nil {
+		return fmt.Errorf("could not rename query '%v': %v", text, err)
+	}
+
+	return store.Commit()
+}
+
+func deleteQueries(texts []string) error {
+	store, err := storage.Open()
+	if err != nil {
+		return fmt.Errorf("could not open storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
+
+	wereErrors := false
+	for _, text := range texts {
+		savedQuery, err := lookupQuery(store, text)
+		if err != nil {
+			log.Warn(err.Error())
+			wereErrors = true
+			continue
+		}
+
+		log.Infof(2, "deleting query '%v'.", savedQuery.Text)
+
+		if err := store.DeleteQuery(savedQuery.Text); err != nil {
+			log.Warnf("could not delete query '%v': %v", text, err)
+			wereErrors = true
+		}
+	}
+
+	return commitChanges(store, wereErrors, false)
+}
+
+// Retrieves the saved query with the specified text or, if prefixed with '@',
+// name.
+func lookupQuery(store *storage.Storage, text string) (*entities.Query, error) {
+	var savedQuery *entities.Query
+	var err error
+
+	if strings.HasPrefix(text, "@") {
+		savedQuery, err = store.QueryByName(text[1:])
+	} else {
+		savedQuery, err = store.Query(text)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve query '%v': %v", text, err)
+	}
+	if savedQuery == nil {
+		return nil, fmt.Errorf("no such query '%v'.", text)
+	}
+
+	return savedQuery, nil
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestQueriesAddAndList(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	// test
+
+	if err := QueriesCommand.Exec(Options{Option{"--add", "-a", "", false, ""}}, []string{"music", "and", "not", "mp3"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := QueriesCommand.Exec(Options{Option{"--add", "-a", "", false, ""}}, []string{"apple or banana"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := QueriesCommand.Exec(Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "apple or banana\nmusic and not mp3\n", string(bytes))
+}
+
+func TestQueriesAddInvalid(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	// test
+
+	if err := QueriesCommand.Exec(Options{Option{"--add", "-a", "", false, ""}}, []string{"music and (mp3"}); err == nil {
+		test.Fatal("Invalid query was saved.")
+	}
+
+	// validate
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	queries, err := store.Queries()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(queries) != 0 {
+		test.Fatalf("Expected no queries but are %v.", len(queries))
+	}
+}
+
+func TestQueriesRename(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddQuery("music and mp3", ""); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := QueriesCommand.Exec(Options{Option{"--rename", "-m", "", false, ""}}, []string{"music and mp3", "music and flac"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	queries, err := store.Queries()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(queries) != 1 {
+		test.Fatalf("Expected one query but are %v.", len(queries))
+	}
+	if queries[0].Text != "music and flac" {
+		test.Fatalf("Expected query 'music and flac' but was '%v'.", queries[0].Text)
+	}
+}
+
+func TestQueriesDelete(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddQuery("music and mp3", ""); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddQuery("apple", ""); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := QueriesCommand.Exec(Options{Option{"--delete", "-d", "", false, ""}}, []string{"music and mp3"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	queries, err := store.Queries()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(queries) != 1 {
+		test.Fatalf("Expected one query but are %v.", len(queries))
+	}
+	if queries[0].Text != "apple" {
+		test.Fatalf("Expected query 'apple' but was '%v'.", queries[0].Text)
+	}
+}
+
+func TestQueriesAddNamed(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	// test
+
+	if err := QueriesCommand.Exec(Options{Option{"--add", "-a", "", false, ""}, Option{"--name", "-n", "", true, "fruit"}}, []string{"apple or banana"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := QueriesCommand.Exec(Options{Option{"--add", "-a", "", false, ""}}, []string{"@fruit and not mouldy"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := QueriesCommand.Exec(Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "@fruit and not mouldy\n@fruit: apple or banana\n", string(bytes))
+}
+
+func TestQueriesRecursiveNamed(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddQuery("apple", "a"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddQuery("@a or banana", "b"); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := QueriesCommand.Exec(Options{Option{"--rename", "-m", "", false, ""}}, []string{"@a", "@b and cherry"}); err == nil {
+		test.Fatal("Recursive query was accepted.")
+	}
+
+	if err := QueriesCommand.Exec(Options{Option{"--delete", "-d", "", false, ""}}, []string{"@a"}); err == nil {
+		test.Fatal("Query referred to by another query was deleted.")
+	}
+
+	// validate
+
+	savedQuery, err := store.QueryByName("a")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if savedQuery == nil || savedQuery.Text != "apple" {
+		test.Fatalf("Expected query '@a' to be unchanged but was '%v'.", savedQuery)
+	}
+}
+
+func TestQueriesDeleteWithUnparsableQuery(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddQuery("apple", "a"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.Db.InsertQuery("(banana", ""); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := QueriesCommand.Exec(Options{Option{"--delete", "-d", "", false, ""}}, []string{"@a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	queries, err := store.Queries()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(queries) != 1 || queries[0].Text != "(banana" {
+		test.Fatalf("Expected only query '(banana' to remain but were %v.", queries)
+	}
+}
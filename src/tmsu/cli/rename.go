@@ -33,6 +33,8 @@ Renames a tag from OLD to NEW.
 Attempting to rename a tag with a new name for which a tag already exists will result in an error.
 To merge tags use the 'merge' command instead.
 
+Saved queries that use the tag are updated to use the new name.
+
 Examples:
 
     $ tmsu rename montain mountain`,
@@ -46,7 +48,11 @@ func renameExec(options Options, args []string) error {
 		return fmt.Errorf("could not open storage: %v", err)
 	}
 	defer store.Close()
-	defer store.Commit()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
 
 	if len(args) < 2 {
 		return fmt.Errorf("tag to rename and new name must both be specified.")
@@ -82,5 +88,5 @@ func renameExec(options Options, args []string) error {
 		return fmt.Errorf("could not rename tag '%v' to '%v': %v", sourceTagName, destTagName, err)
 	}
 
-	return nil
+	return store.Commit()
 }
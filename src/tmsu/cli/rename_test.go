@@ -161,3 +161,55 @@ func TestRenameDestTagAlreadyExists(test *testing.T) {
 		test.Fatal("Existing dest tag not identified.")
 	}
 }
+
+func TestRenameUpdatesSavedQueries(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddTag("montain"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddTag("lake"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddQuery("(montain or lake) and not montain=alps", "scenery"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddQuery("lake", ""); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := RenameCommand.Exec(Options{}, []string{"montain", "mountain"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	savedQuery, err := store.QueryByName("scenery")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if savedQuery.Text != "(mountain or lake) and not mountain=alps" {
+		test.Fatalf("Expected saved query to be rewritten but was '%v'.", savedQuery.Text)
+	}
+
+	savedQuery, err = store.Query("lake")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if savedQuery == nil {
+		test.Fatal("Unrelated saved query was changed.")
+	}
+}
@@ -21,17 +21,20 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"tmsu/common/fingerprint"
 	"tmsu/common/log"
 	_path "tmsu/common/path"
 	"tmsu/entities"
 	"tmsu/storage"
+	"tmsu/storage/database"
 )
 
 var RepairCommand = Command{
 	Name:     "repair",
 	Synopsis: "Repair the database",
 	Description: `tmsu [OPTION]... repair [PATH]...
+tmsu [OPTION]... repair --remap OLD NEW
 
 Fixes broken paths and stale fingerprints in the database caused by file
 modifications and moves.
@@ -60,26 +63,60 @@ tagging information then use the --force option.
 
 Untagged files are reported but not added to the database.
 
+The --remap option updates the paths of all files under the directory OLD so
+that they are instead under NEW, e.g. when a disk is mounted at a different
+location. Every remapped path must exist: if any does not then nothing is
+remapped. Use --pretend to preview the changes.
+
+When the --refingerprint option is specified the fingerprints of unmodified
+files are also recalculated using the current fingerprint algorithm. This is
+necessary after changing the 'fingerprintAlgorithm' setting (see the 'config'
+command) as otherwise moved files and duplicates cannot be identified.
+
 Examples:
 
     $ tmsu repair
     $ tmsu repair .
-    $ tmsu repair --force`,
+    $ tmsu repair --force
+    $ tmsu repair --refingerprint
+    $ tmsu repair --remap /media/old /mnt/archive`,
 	Options: Options{{"--pretend", "-p", "do not make any changes", false, ""},
-		{"--force", "-f", "remove missing files from the database", false, ""}},
+		{"--force", "-f", "remove missing files from the database", false, ""},
+		{"--refingerprint", "-r", "recalculate fingerprints of unmodified files", false, ""},
+		{"--remap", "-m", "remap paths under OLD to NEW", false, ""}},
 	Exec: repairExec,
 }
 
 func repairExec(options Options, args []string) error {
 	pretend := options.HasOption("--pretend")
 	force := options.HasOption("--force")
+	refingerprint := options.HasOption("--refingerprint")
 
 	store, err := storage.Open()
 	if err != nil {
 		return fmt.Errorf("could not open storage: %v", err)
 	}
 	defer store.Close()
-	defer store.Commit()
+
+	// the write lock is not needed to pretend as no changes are made
+	if !pretend {
+		if err := store.Begin(); err != nil {
+			return err
+		}
+		defer store.Rollback()
+	}
+
+	if options.HasOption("--remap") {
+		if len(args) != 2 {
+			return fmt.Errorf("the old and new paths must be specified.")
+		}
+
+		if err := repairRemap(store, args[0], args[1], pretend); err != nil {
+			return err
+		}
+
+		return commitRepairs(store, pretend)
+	}
 
 	fingerprintAlgorithmSetting, err := store.Setting("fingerprintAlgorithm")
 	if err != nil {
@@ -87,36 +124,146 @@ func repairExec(options Options, args []string) error {
 	}
 
 	if len(args) == 0 {
-		return repairDatabase(store, pretend, force, fingerprintAlgorithmSetting.Value)
+		err = repairDatabase(store, pretend, force, refingerprint, fingerprintAlgorithmSetting.Value)
+	} else {
+		err = repairPaths(store, args, pretend, force, refingerprint, fingerprintAlgorithmSetting.Value)
+	}
+	if err != nil {
+		return err
 	}
 
-	return repairPaths(store, args, pretend, force, fingerprintAlgorithmSetting.Value)
+	return commitRepairs(store, pretend)
 }
 
 //- unexported
 
-func repairDatabase(store *storage.Storage, pretend, force bool, fingerprintAlgorithm string) error {
+// Commits the repairs, unless pretending in which case there are none.
+func commitRepairs(store *storage.Storage, pretend bool) error {
+	if pretend {
+		return nil
+	}
+
+	return store.Commit()
+}
+
+func repairDatabase(store *storage.Storage, pretend, force, refingerprint bool, fingerprintAlgorithm string) error {
 	log.Infof(2, "retrieving all files from the database.")
 
-	files, err := store.Files()
+	// the files are retrieved once: both to find the top-level paths and to
+	// compare with the file-system
+	tree := _path.NewTree()
+	dbPaths := make(databaseFileMap, 100)
+	err := store.ForEachFile(database.FileSelection{}, func(file *entities.File) error {
+		tree.Add(file.Path(), file.IsDir)
+		dbPaths[file.Path()] = *file
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("could not retrieve paths from storage: %v", err)
 	}
 
-	paths := make([]string, len(files))
-	for index := 0; index < len(files); index++ {
-		paths[index] = files[index].Path()
+	fsPaths, err := enumerateFileSystemPaths(tree.TopLevel().Paths())
+	if err != nil {
+		return err
 	}
 
-	err = repairFiles(store, paths, pretend, force, fingerprintAlgorithm)
+	return repairStatuses(store, fsPaths, dbPaths, pretend, force, refingerprint, fingerprintAlgorithm)
+}
+
+func repairRemap(store *storage.Storage, oldPath, newPath string, pretend bool) error {
+	absOldPath, err := filepath.Abs(oldPath)
 	if err != nil {
-		return err
+		return fmt.Errorf("%v: could not get absolute path: %v", oldPath, err)
+	}
+
+	absNewPath, err := filepath.Abs(newPath)
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", newPath, err)
+	}
+
+	log.Infof(2, "retrieving files under '%v'.", absOldPath)
+
+	files, err := store.FilesByDirectory(absOldPath)
+	if err != nil {
+		return fmt.Errorf("could not retrieve files from storage: %v", err)
+	}
+
+	// the directory is matched case-insensitively so this makes the match
+	// case-sensitive
+	prefix := absOldPath
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	files = files.Where(func(file *entities.File) bool {
+		return strings.HasPrefix(file.Path(), prefix)
+	})
+
+	file, err := store.FileByPath(absOldPath)
+	if err != nil {
+		return fmt.Errorf("%v: could not retrieve file from storage: %v", absOldPath, err)
+	}
+	if file != nil {
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("%v: no files in the database under this path.", absOldPath)
+	}
+
+	log.Infof(2, "checking new paths.")
+
+	remappedPaths := make([]string, len(files))
+	wereErrors := false
+	for index, file := range files {
+		remappedPath := absNewPath
+		if file.Path() != absOldPath {
+			remappedPath = filepath.Join(absNewPath, file.Path()[len(prefix):])
+		}
+
+		if _, err := os.Stat(remappedPath); err != nil {
+			switch {
+			case os.IsNotExist(err):
+				log.Warnf("%v: not found", remappedPath)
+			default:
+				log.Warnf("%v: could not stat file: %v", remappedPath, err)
+			}
+
+			wereErrors = true
+			continue
+		}
+
+		existingFile, err := store.FileByPath(remappedPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file from storage: %v", remappedPath, err)
+		}
+		if existingFile != nil {
+			log.Warnf("%v: already in the database", remappedPath)
+			wereErrors = true
+			continue
+		}
+
+		remappedPaths[index] = remappedPath
+	}
+
+	if wereErrors {
+		return fmt.Errorf("could not remap '%v' to '%v': no changes made.", absOldPath, absNewPath)
+	}
+
+	for index, file := range files {
+		log.Infof(1, "%v: remapped to %v", file.Path(), remappedPaths[index])
+
+		if !pretend {
+			_, err := store.UpdateFile(file.Id, remappedPaths[index], file.Fingerprint, file.ModTime, file.Size, file.IsDir)
+			if err != nil {
+				return fmt.Errorf("%v: could not update file in database: %v", file.Path(), err)
+			}
+		}
 	}
 
 	return nil
 }
 
-func repairPaths(store *storage.Storage, paths []string, pretend, force bool, fingerprintAlgorithm string) error {
+func repairPaths(store *storage.Storage, paths []string, pretend, force, refingerprint bool, fingerprintAlgorithm string) error {
 	absPaths := make([]string, len(paths))
 
 	for index, path := range paths {
@@ -130,7 +277,7 @@ func repairPaths(store *storage.Storage, paths []string, pretend, force bool, fi
 
 	log.Infof(2, "identifying top-level paths.")
 
-	err := repairFiles(store, absPaths, pretend, force, fingerprintAlgorithm)
+	err := repairFiles(store, absPaths, pretend, force, refingerprint, fingerprintAlgorithm)
 	if err != nil {
 		return err
 	}
@@ -138,7 +285,7 @@ func repairPaths(store *storage.Storage, paths []string, pretend, force bool, fi
 	return nil
 }
 
-func repairFiles(store *storage.Storage, paths []string, pretend, force bool, fingerprintAlgorithm string) error {
+func repairFiles(store *storage.Storage, paths []string, pretend, force, refingerprint bool, fingerprintAlgorithm string) error {
 	tree := _path.NewTree()
 	for _, path := range paths {
 		tree.Add(path, false)
@@ -155,17 +302,29 @@ func repairFiles(store *storage.Storage, paths []string, pretend, force bool, fi
 		return err
 	}
 
-	_, untagged, modified, missing := determineStatuses(fsPaths, dbPaths)
+	return repairStatuses(store, fsPaths, dbPaths, pretend, force, refingerprint, fingerprintAlgorithm)
+}
+
+// Repairs the database entries for the files on the file-system and in the
+// database.
+func repairStatuses(store *storage.Storage, fsPaths fileInfoMap, dbPaths databaseFileMap, pretend, force, refingerprint bool, fingerprintAlgorithm string) error {
+	tagged, untagged, modified, missing := determineStatuses(fsPaths, dbPaths)
+
+	if refingerprint {
+		if err := repairFingerprints(store, tagged, pretend, fingerprintAlgorithm); err != nil {
+			return err
+		}
+	}
 
-	if err = repairModified(store, modified, pretend, fingerprintAlgorithm); err != nil {
+	if err := repairModified(store, modified, pretend, fingerprintAlgorithm); err != nil {
 		return err
 	}
 
-	if err = repairMoved(store, missing, untagged, pretend, fingerprintAlgorithm); err != nil {
+	if err := repairMoved(store, missing, untagged, pretend, fingerprintAlgorithm); err != nil {
 		return err
 	}
 
-	if err = repairMissing(store, missing, pretend, force); err != nil {
+	if err := repairMissing(store, missing, pretend, force); err != nil {
 		return err
 	}
 
@@ -244,6 +403,32 @@ func repairModified(store *storage.Storage, modified fileIdAndInfoMap, pretend b
 	return nil
 }
 
+func repairFingerprints(store *storage.Storage, tagged databaseFileMap, pretend bool, fingerprintAlgorithm string) error {
+	log.Infof(2, "recalculating fingerprints")
+
+	for path, dbFile := range tagged {
+		fingerprint, err := fingerprint.Create(path, fingerprintAlgorithm)
+		if err != nil {
+			return fmt.Errorf("%v: could not create fingerprint: %v", path, err)
+		}
+
+		if fingerprint == dbFile.Fingerprint {
+			continue
+		}
+
+		log.Infof(1, "%v: refingerprinted", path)
+
+		if !pretend {
+			_, err := store.UpdateFile(dbFile.Id, path, fingerprint, dbFile.ModTime, dbFile.Size, dbFile.IsDir)
+			if err != nil {
+				return fmt.Errorf("%v: could not update file in database: %v", path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 func repairMoved(store *storage.Storage, missing databaseFileMap, untagged fileInfoMap, pretend bool, fingerprintAlgorithm string) error {
 	log.Infof(2, "repairing moved files")
 
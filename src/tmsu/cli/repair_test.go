@@ -21,7 +21,10 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
+	"tmsu/common/fingerprint"
 	"tmsu/storage"
+	"tmsu/storage/database"
 )
 
 func TestRepairMovedFile(test *testing.T) {
@@ -73,6 +76,58 @@ func TestRepairMovedFile(test *testing.T) {
 	}
 }
 
+func TestRepairPretendWhileLocked(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	defer func(timeout time.Duration) { database.BusyTimeout = timeout }(database.BusyTimeout)
+	database.BusyTimeout = 100 * time.Millisecond
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+	defer os.Remove("/tmp/tmsu/b")
+
+	if err := TagCommand.Exec(Options{}, []string{"/tmp/tmsu/a", "a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := os.Rename("/tmp/tmsu/a", "/tmp/tmsu/b"); err != nil {
+		test.Fatal(err)
+	}
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+	defer store.Rollback()
+
+	// test
+
+	if err := RepairCommand.Exec(Options{Option{"--pretend", "-p", "", false, ""}}, []string{"/tmp/tmsu"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0].Path() != "/tmp/tmsu/a" {
+		test.Fatalf("Files were changed despite pretending.")
+	}
+}
+
 func TestRepairModifiedFile(test *testing.T) {
 	// set-up
 
@@ -167,3 +222,185 @@ func TestReportsMissingFiles(test *testing.T) {
 	bytes, err := ioutil.ReadAll(outFile)
 	compareOutput(test, "tmsu: /tmp/tmsu/a: missing\n", string(bytes))
 }
+
+func TestRepairRemap(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	defer os.RemoveAll("/tmp/tmsu-old")
+	defer os.RemoveAll("/tmp/tmsu-new")
+
+	if err := createFile("/tmp/tmsu-old/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	if err := createFile("/tmp/tmsu-old/b/c", "world"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(Options{}, []string{"/tmp/tmsu-old/a", "a"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := TagCommand.Exec(Options{}, []string{"/tmp/tmsu-old/b/c", "c"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := os.Rename("/tmp/tmsu-old", "/tmp/tmsu-new"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := RepairCommand.Exec(Options{Option{"--remap", "-m", "", false, ""}}, []string{"/tmp/tmsu-old", "/tmp/tmsu-new"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(files) != 2 {
+		test.Fatalf("Expected two files but are %v", len(files))
+	}
+	if files[0].Path() != "/tmp/tmsu-new/a" {
+		test.Fatalf("Expected '/tmp/tmsu-new/a' but was '%v'.", files[0].Path())
+	}
+	if files[1].Path() != "/tmp/tmsu-new/b/c" {
+		test.Fatalf("Expected '/tmp/tmsu-new/b/c' but was '%v'.", files[1].Path())
+	}
+}
+
+func TestRepairRemapRoot(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	defer os.RemoveAll("/tmp/tmsu-new")
+
+	if err := createFile("/tmp/tmsu-new/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	if err := createFile("/tmp/tmsu-new/b/c", "world"); err != nil {
+		test.Fatal(err)
+	}
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddFile("/a", fingerprint.Fingerprint("abc"), time.Now(), 5, false); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFile("/b/c", fingerprint.Fingerprint("abc"), time.Now(), 5, false); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Commit()
+
+	// test
+
+	if err := RepairCommand.Exec(Options{Option{"--remap", "-m", "", false, ""}}, []string{"/", "/tmp/tmsu-new"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(files) != 2 {
+		test.Fatalf("Expected two files but are %v", len(files))
+	}
+	if files[0].Path() != "/tmp/tmsu-new/a" {
+		test.Fatalf("Expected '/tmp/tmsu-new/a' but was '%v'.", files[0].Path())
+	}
+	if files[1].Path() != "/tmp/tmsu-new/b/c" {
+		test.Fatalf("Expected '/tmp/tmsu-new/b/c' but was '%v'.", files[1].Path())
+	}
+}
+
+func TestRepairRemapMissingPath(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	defer os.RemoveAll("/tmp/tmsu-old")
+	defer os.RemoveAll("/tmp/tmsu-new")
+
+	if err := createFile("/tmp/tmsu-old/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	if err := createFile("/tmp/tmsu-old/b", "world"); err != nil {
+		test.Fatal(err)
+	}
+	if err := createFile("/tmp/tmsu-new/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(Options{}, []string{"/tmp/tmsu-old/a", "a"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := TagCommand.Exec(Options{}, []string{"/tmp/tmsu-old/b", "b"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := RepairCommand.Exec(Options{Option{"--remap", "-m", "", false, ""}}, []string{"/tmp/tmsu-old", "/tmp/tmsu-new"}); err == nil {
+		test.Fatal("Remap to missing path succeeded.")
+	}
+
+	// validate
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(files) != 2 {
+		test.Fatalf("Expected two files but are %v", len(files))
+	}
+	if files[0].Path() != "/tmp/tmsu-old/a" || files[1].Path() != "/tmp/tmsu-old/b" {
+		test.Fatalf("Files were remapped despite missing path.")
+	}
+}
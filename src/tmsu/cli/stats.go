@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"math"
 	"tmsu/storage"
+	"tmsu/storage/database"
 )
 
 var StatsCommand = Command{
@@ -28,7 +29,7 @@ var StatsCommand = Command{
 	Synopsis: "Show database statistics",
 	Description: `tmsu stats
 
-Shows the database statistics.`,
+Shows the database statistics, including the path of the database in use.`,
 	Options: Options{},
 	Exec:    statsExec,
 }
@@ -70,6 +71,11 @@ func statsExec(options Options, args []string) error {
 		averageFilesPerTag = float32(fileTagCount) / float32(tagCount)
 	}
 
+	fmt.Println("DATABASE")
+	fmt.Println()
+	fmt.Printf("  Path: %v\n", database.Path)
+	fmt.Println()
+
 	fmt.Println("COUNTS")
 	fmt.Println()
 	fmt.Printf("  Tags:     %v\n", tagCount)
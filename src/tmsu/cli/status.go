@@ -26,6 +26,7 @@ import (
 	"tmsu/common/path"
 	"tmsu/entities"
 	"tmsu/storage"
+	"tmsu/storage/database"
 )
 
 var StatusCommand = Command{
@@ -129,21 +130,19 @@ func statusDatabase(dirOnly bool) (*StatusReport, error) {
 
 	log.Info(2, "retrieving all files from database.")
 
-	files, err := store.Files()
-	if err != nil {
-		return nil, fmt.Errorf("could not retrieve files: %v", err)
-	}
+	tree := path.NewTree()
+	err = store.ForEachFile(database.FileSelection{}, func(file *entities.File) error {
+		if err := statusCheckFile(file, report); err != nil {
+			return err
+		}
 
-	err = statusCheckFiles(files, report)
+		tree.Add(file.Path(), file.IsDir)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	tree := path.NewTree()
-	for _, file := range files {
-		tree.Add(file.Path(), file.IsDir)
-	}
-
 	topLevelPaths := tree.TopLevel().Paths()
 	if err != nil {
 		return nil, err
@@ -166,7 +165,6 @@ func statusPaths(paths []string, dirOnly bool) (*StatusReport, error) {
 		return nil, fmt.Errorf("could not open storage: %v", err)
 	}
 	defer store.Close()
-	defer store.Commit()
 
 	for _, path := range paths {
 		absPath, err := filepath.Abs(path)
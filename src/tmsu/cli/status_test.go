@@ -21,6 +21,8 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"tmsu/storage"
+	"tmsu/storage/database"
 )
 
 func TestStatusReport(test *testing.T) {
@@ -91,3 +93,62 @@ func TestStatusReport(test *testing.T) {
 	bytes, err := ioutil.ReadAll(outFile)
 	compareOutput(test, "T /tmp/tmsu/a\nM /tmp/tmsu/b\n! /tmp/tmsu/d\nU /tmp/tmsu/c\n", string(bytes))
 }
+
+func TestStatusLocalDatabaseMoved(test *testing.T) {
+	// set-up
+
+	defer os.RemoveAll("/tmp/tmsu-local")
+	defer os.RemoveAll("/tmp/tmsu-moved")
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	if err := InitCommand.Exec(Options{}, []string{"/tmp/tmsu-local"}); err != nil {
+		test.Fatal(err)
+	}
+	database.Path = database.LocalPath("/tmp/tmsu-local")
+
+	if err := createFile("/tmp/tmsu-local/a", "a"); err != nil {
+		test.Fatalf("Could not create file: %v", err)
+	}
+
+	if err := TagCommand.Exec(Options{}, []string{"/tmp/tmsu-local/a", "a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := os.Rename("/tmp/tmsu-local", "/tmp/tmsu-moved"); err != nil {
+		test.Fatal(err)
+	}
+	database.Path = database.LocalPath("/tmp/tmsu-moved")
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	files, err := store.Db.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path() != "a" {
+		test.Fatalf("Expected file to be stored with a relative path.")
+	}
+
+	store.Close()
+
+	// test
+
+	if err := StatusCommand.Exec(Options{}, []string{"/tmp/tmsu-moved/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "T /tmp/tmsu-moved/a\n", string(bytes))
+}
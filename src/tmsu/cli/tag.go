@@ -26,6 +26,7 @@ import (
 	"tmsu/common/fingerprint"
 	"tmsu/common/log"
 	"tmsu/entities"
+	"tmsu/query"
 	"tmsu/storage"
 )
 
@@ -46,23 +47,28 @@ contain whitespace characters, the comparison operator symbols ('=', '<' and
 addition, the tag names '.' and '..' are not valid.
 
 Optionally tags applied to files may be attributed with a VALUE using the
-TAG=VALUE syntax.
+TAG=VALUE syntax. Values may additionally contain spaces, the comparison
+operator symbols and parentheses. Within the --tags option such values must be
+quoted as in a query, e.g. --tags='title="Blue Train"'.
 
 Examples:
 
     $ tmsu tag mountain1.jpg photo landscape holiday good country=france
     $ tmsu tag --from=mountain1.jpg mountain2.jpg
     $ tmsu tag --tags="landscape" field1.jpg field2.jpg
+    $ tmsu tag --tags='title="Blue Train" year=1957' bluetrain.mp3
     $ tmsu tag --create bad rubbish awful`,
 	Options: Options{{"--tags", "-t", "the set of tags to apply", true, ""},
 		{"--recursive", "-r", "recursively apply tags to directory contents", false, ""},
 		{"--from", "-f", "copy tags from the specified file", true, ""},
-		{"--create", "-c", "create a tag without tagging any files", false, ""}},
+		{"--create", "-c", "create a tag without tagging any files", false, ""},
+		{"--continue-on-error", "-C", "apply the successful changes despite errors", false, ""}},
 	Exec: tagExec,
 }
 
 func tagExec(options Options, args []string) error {
 	recursive := options.HasOption("--recursive")
+	continueOnError := options.HasOption("--continue-on-error")
 
 	switch {
 	case options.HasOption("--create"):
@@ -78,7 +84,10 @@ func tagExec(options Options, args []string) error {
 			return fmt.Errorf("files to tag must be specified")
 		}
 
-		tagArgs := strings.Fields(options.Get("--tags").Argument)
+		tagArgs, err := query.SplitTags(options.Get("--tags").Argument)
+		if err != nil {
+			return fmt.Errorf("could not parse tags: %v", err)
+		}
 		if len(tagArgs) == 0 {
 			return fmt.Errorf("set of tags to apply must be specified")
 		}
@@ -88,7 +97,7 @@ func tagExec(options Options, args []string) error {
 			return fmt.Errorf("at least one file to tag must be specified")
 		}
 
-		if err := tagPaths(tagArgs, paths, recursive); err != nil {
+		if err := tagPaths(tagArgs, paths, recursive, continueOnError); err != nil {
 			return err
 		}
 	case options.HasOption("--from"):
@@ -103,7 +112,7 @@ func tagExec(options Options, args []string) error {
 
 		paths := args
 
-		if err := tagFrom(fromPath, paths, recursive); err != nil {
+		if err := tagFrom(fromPath, paths, recursive, continueOnError); err != nil {
 			return err
 		}
 	default:
@@ -112,9 +121,12 @@ func tagExec(options Options, args []string) error {
 		}
 
 		paths := args[0:1]
-		tagArgs := args[1:]
+		tagArgs, err := unquoteTagArgs(args[1:])
+		if err != nil {
+			return fmt.Errorf("could not parse tags: %v", err)
+		}
 
-		if err := tagPaths(tagArgs, paths, recursive); err != nil {
+		if err := tagPaths(tagArgs, paths, recursive, continueOnError); err != nil {
 			return err
 		}
 	}
@@ -122,13 +134,33 @@ func tagExec(options Options, args []string) error {
 	return nil
 }
 
+// Removes the quotes and backslash escapes from the tags given as arguments, as
+// for those given with --tags, e.g. 'title="Blue Train"' is the value 'Blue Train'.
+func unquoteTagArgs(args []string) ([]string, error) {
+	tagArgs := make([]string, len(args))
+	for index, arg := range args {
+		tagArg, err := query.UnquoteTag(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		tagArgs[index] = tagArg
+	}
+
+	return tagArgs, nil
+}
+
 func createTags(names []string) error {
 	store, err := storage.Open()
 	if err != nil {
 		return fmt.Errorf("could not open storage: %v", err)
 	}
 	defer store.Close()
-	defer store.Commit()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
 
 	tags, err := store.TagsByNames(names)
 	if err != nil {
@@ -152,16 +184,20 @@ func createTags(names []string) error {
 		}
 	}
 
-	return nil
+	return store.Commit()
 }
 
-func tagPaths(tagArgs, paths []string, recursive bool) error {
+func tagPaths(tagArgs, paths []string, recursive, continueOnError bool) error {
 	store, err := storage.Open()
 	if err != nil {
 		return fmt.Errorf("could not open storage: %v", err)
 	}
 	defer store.Close()
-	defer store.Commit()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
 
 	fingerprintAlgorithmSetting, err := store.Setting("fingerprintAlgorithm")
 	if err != nil {
@@ -210,20 +246,20 @@ func tagPaths(tagArgs, paths []string, recursive bool) error {
 		}
 	}
 
-	if wereErrors {
-		return blankError
-	}
-
-	return nil
+	return commitChanges(store, wereErrors, continueOnError)
 }
 
-func tagFrom(fromPath string, paths []string, recursive bool) error {
+func tagFrom(fromPath string, paths []string, recursive, continueOnError bool) error {
 	store, err := storage.Open()
 	if err != nil {
 		return fmt.Errorf("could not open storage: %v", err)
 	}
 	defer store.Close()
-	defer store.Commit()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
 
 	fingerprintAlgorithmSetting, err := store.Setting("fingerprintAlgorithm")
 	if err != nil {
@@ -264,11 +300,7 @@ func tagFrom(fromPath string, paths []string, recursive bool) error {
 		}
 	}
 
-	if wereErrors {
-		return blankError
-	}
-
-	return nil
+	return commitChanges(store, wereErrors, continueOnError)
 }
 
 func tagPath(store *storage.Storage, path string, tagValuePairs []TagValuePair, recursive bool, fingerprintAlgorithm string) error {
@@ -18,9 +18,12 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package cli
 
 import (
+	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 	"tmsu/storage"
+	"tmsu/storage/database"
 )
 
 func TestSingleTag(test *testing.T) {
@@ -264,3 +267,303 @@ func TestTagMultipleFiles(test *testing.T) {
 }
 
 //TODO recursive
+
+func TestTagMissingFileMakesNoChanges(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	// test
+
+	if err := TagCommand.Exec(Options{Option{"--tags", "-t", "", true, "apple"}}, []string{"/tmp/tmsu/a", "/tmp/tmsu/missing"}); err == nil {
+		test.Fatal("Tagging a missing file succeeded.")
+	}
+
+	// validate
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 0 {
+		test.Fatalf("Expected no files but are %v", len(files))
+	}
+
+	tags, err := store.Tags()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(tags) != 0 {
+		test.Fatalf("Expected no tags but are %v", len(tags))
+	}
+}
+
+func TestTagMissingFileContinueOnError(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	// test
+
+	options := Options{Option{"--tags", "-t", "", true, "apple"},
+		Option{"--continue-on-error", "-C", "", false, ""}}
+	if err := TagCommand.Exec(options, []string{"/tmp/tmsu/a", "/tmp/tmsu/missing"}); err == nil {
+		test.Fatal("Tagging a missing file succeeded.")
+	}
+
+	// validate
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 1 {
+		test.Fatalf("Expected one file but are %v", len(files))
+	}
+	if files[0].Path() != "/tmp/tmsu/a" {
+		test.Fatalf("Incorrect file was added.")
+	}
+}
+
+func TestTagTypedValue(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := ConfigCommand.Exec(Options{}, []string{"tag", "year", "type=int"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := TagCommand.Exec(Options{}, []string{"/tmp/tmsu/a", "year=unknown"}); err == nil {
+		test.Fatal("Value of the wrong type was accepted.")
+	}
+
+	if err := TagCommand.Exec(Options{}, []string{"/tmp/tmsu/a", "year=2014"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileTags, err := store.FileTags()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(fileTags) != 1 {
+		test.Fatalf("Expected one file-tag but are %v", len(fileTags))
+	}
+
+	value, err := store.Value(fileTags[0].ValueId)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if value.Name != "2014" {
+		test.Fatalf("Expected value '2014' but was '%v'.", value.Name)
+	}
+}
+
+func TestTagWildcardName(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	// test
+
+	if err := TagCommand.Exec(Options{}, []string{"/tmp/tmsu/a", "genre-*"}); err == nil {
+		test.Fatal("Tag name containing a wildcard was accepted.")
+	}
+}
+
+func TestTagQuotedValues(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	// test
+
+	if err := TagCommand.Exec(Options{Option{"--tags", "-t", "", true, `title="Blue Train" note='a<b' artist=O'Brien`}}, []string{"/tmp/tmsu/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagsCommand.Exec(Options{}, []string{"/tmp/tmsu/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := FilesCommand.Exec(Options{}, []string{`title="Blue Train" and note="a<b" and artist=O'Brien`}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "artist=O'Brien\nnote=\"a<b\"\ntitle=\"Blue Train\"\n/tmp/tmsu/a\n", string(bytes))
+}
+
+func TestTagQuotedArguments(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	// test
+
+	if err := TagCommand.Exec(Options{}, []string{"/tmp/tmsu/a", `title="Blue Train"`, `note=a\<b`, "studio=Van Gelder"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagsCommand.Exec(Options{}, []string{"/tmp/tmsu/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := UntagCommand.Exec(Options{}, []string{"/tmp/tmsu/a", `title="Blue Train"`}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagsCommand.Exec(Options{}, []string{"/tmp/tmsu/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "note=\"a<b\"\nstudio=\"Van Gelder\"\ntitle=\"Blue Train\"\nnote=\"a<b\"\nstudio=\"Van Gelder\"\n", string(bytes))
+}
+
+func TestTagDatabaseLocked(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	defer func(timeout time.Duration) { database.BusyTimeout = timeout }(database.BusyTimeout)
+	database.BusyTimeout = 100 * time.Millisecond
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	store, err := storage.Open()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	err = TagCommand.Exec(Options{}, []string{"/tmp/tmsu/a", "apple"})
+
+	// validate
+
+	if _, ok := err.(database.LockedError); !ok {
+		test.Fatalf("Expected database locked error but was: %v", err)
+	}
+
+	store.Rollback()
+
+	tags, err := store.Tags()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(tags) != 0 {
+		test.Fatalf("Expected no tags but are %v", len(tags))
+	}
+}
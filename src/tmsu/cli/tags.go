@@ -26,6 +26,7 @@ import (
 	"tmsu/common/format"
 	"tmsu/common/log"
 	"tmsu/entities"
+	"tmsu/query"
 	"tmsu/storage"
 )
 
@@ -38,6 +39,9 @@ Lists the tags applied to FILEs.
 
 When run with no arguments, tags for the current working directory are listed.
 
+Tags that are not applied to a file directly but are implied by its other tags
+(see the 'imply' command) are shown in parentheses.
+
 Examples:
 
     $ tmsu tags
@@ -50,6 +54,7 @@ Examples:
     3`,
 	Options: Options{{"--all", "-a", "lists all of the tags defined", false, ""},
 		{"--count", "-c", "lists the number of tags rather than their names", false, ""},
+		{"--explicit", "-e", "list only explicitly applied tags (not implied tags)", false, ""},
 		{"", "-1", "list one tag per line", false, ""}},
 	Exec: tagsExec,
 }
@@ -58,12 +63,13 @@ func tagsExec(options Options, args []string) error {
 	showCount := options.HasOption("--count")
 
 	onePerLine := options.HasOption("-1")
+	explicitOnly := options.HasOption("--explicit")
 
 	if options.HasOption("--all") {
 		return listAllTags(showCount, onePerLine)
 	}
 
-	return listTags(args, showCount, onePerLine)
+	return listTags(args, showCount, onePerLine, explicitOnly)
 }
 
 func listAllTags(showCount, onePerLine bool) error {
@@ -105,7 +111,7 @@ func listAllTags(showCount, onePerLine bool) error {
 	return nil
 }
 
-func listTags(paths []string, showCount, onePerLine bool) error {
+func listTags(paths []string, showCount, onePerLine, explicitOnly bool) error {
 	store, err := storage.Open()
 	if err != nil {
 		return fmt.Errorf("could not open storage: %v", err)
@@ -114,17 +120,17 @@ func listTags(paths []string, showCount, onePerLine bool) error {
 
 	switch len(paths) {
 	case 0:
-		return listTagsForWorkingDirectory(store, showCount, onePerLine)
+		return listTagsForWorkingDirectory(store, showCount, onePerLine, explicitOnly)
 	case 1:
-		return listTagsForPath(store, paths[0], showCount, onePerLine)
+		return listTagsForPath(store, paths[0], showCount, onePerLine, explicitOnly)
 	default:
-		return listTagsForPaths(store, paths, showCount, onePerLine)
+		return listTagsForPaths(store, paths, showCount, onePerLine, explicitOnly)
 	}
 
 	return nil
 }
 
-func listTagsForPath(store *storage.Storage, path string, showCount, onePerLine bool) error {
+func listTagsForPath(store *storage.Storage, path string, showCount, onePerLine, explicitOnly bool) error {
 	log.Infof(2, "%v: retrieving tags.", path)
 
 	file, err := store.FileByPath(path)
@@ -139,7 +145,7 @@ func listTagsForPath(store *storage.Storage, path string, showCount, onePerLine
 			return fmt.Errorf("%v: could not retrieve file-tags: %v", path, err)
 		}
 
-		tagNames, err = lookupTagNames(store, fileTags)
+		tagNames, err = lookupTagNames(store, fileTags, explicitOnly)
 		if err != nil {
 			return err
 		}
@@ -172,7 +178,7 @@ func listTagsForPath(store *storage.Storage, path string, showCount, onePerLine
 	return nil
 }
 
-func listTagsForPaths(store *storage.Storage, paths []string, showCount, onePerLine bool) error {
+func listTagsForPaths(store *storage.Storage, paths []string, showCount, onePerLine, explicitOnly bool) error {
 	wereErrors := false
 	for _, path := range paths {
 		log.Infof(2, "%v: retrieving tags.", path)
@@ -190,7 +196,7 @@ func listTagsForPaths(store *storage.Storage, paths []string, showCount, onePerL
 				return err
 			}
 
-			tagNames, err = lookupTagNames(store, fileTags)
+			tagNames, err = lookupTagNames(store, fileTags, explicitOnly)
 			if err != nil {
 				return err
 			}
@@ -234,7 +240,7 @@ func listTagsForPaths(store *storage.Storage, paths []string, showCount, onePerL
 	return nil
 }
 
-func listTagsForWorkingDirectory(store *storage.Storage, showCount, onePerLine bool) error {
+func listTagsForWorkingDirectory(store *storage.Storage, showCount, onePerLine, explicitOnly bool) error {
 	file, err := os.Open(".")
 	if err != nil {
 		return fmt.Errorf("could not open working directory: %v", err)
@@ -265,7 +271,7 @@ func listTagsForWorkingDirectory(store *storage.Storage, showCount, onePerLine b
 			return fmt.Errorf("could not retrieve file-tags: %v", err)
 		}
 
-		tagNames, err := lookupTagNames(store, fileTags)
+		tagNames, err := lookupTagNames(store, fileTags, explicitOnly)
 		if err != nil {
 			return err
 		}
@@ -288,8 +294,9 @@ func listTagsForWorkingDirectory(store *storage.Storage, showCount, onePerLine b
 	return nil
 }
 
-func lookupTagNames(store *storage.Storage, fileTags entities.FileTags) ([]string, error) {
+func lookupTagNames(store *storage.Storage, fileTags entities.FileTags, explicitOnly bool) ([]string, error) {
 	tagNames := make([]string, 0, len(fileTags))
+	tagIds := make([]uint, 0, len(fileTags))
 
 	for _, fileTag := range fileTags {
 		tag, err := store.Tag(fileTag.TagId)
@@ -300,6 +307,8 @@ func lookupTagNames(store *storage.Storage, fileTags entities.FileTags) ([]strin
 			return nil, fmt.Errorf("tag '%v' does not exist", fileTag.TagId)
 		}
 
+		tagIds = append(tagIds, tag.Id)
+
 		var tagName string
 		if fileTag.ValueId == 0 {
 			tagName = tag.Name
@@ -312,7 +321,7 @@ func lookupTagNames(store *storage.Storage, fileTags entities.FileTags) ([]strin
 				return nil, fmt.Errorf("value '%v' does not exist", fileTag.ValueId)
 			}
 
-			tagName = tag.Name + "=" + value.Name
+			tagName = tag.Name + "=" + query.Quote(value.Name)
 		}
 
 		tagNames = append(tagNames, tagName)
@@ -320,5 +329,20 @@ func lookupTagNames(store *storage.Storage, fileTags entities.FileTags) ([]strin
 
 	sort.Strings(tagNames)
 
+	if explicitOnly || len(tagIds) == 0 {
+		return tagNames, nil
+	}
+
+	impliedTags, err := store.ImpliedTags(tagIds)
+	if err != nil {
+		return nil, fmt.Errorf("could not lookup implied tags: %v", err)
+	}
+
+	sort.Sort(impliedTags)
+
+	for _, impliedTag := range impliedTags {
+		tagNames = append(tagNames, "("+impliedTag.Name+")")
+	}
+
 	return tagNames, nil
 }
@@ -22,6 +22,7 @@ import (
 	"path/filepath"
 	"strings"
 	"tmsu/common/log"
+	"tmsu/query"
 	"tmsu/storage"
 )
 
@@ -41,7 +42,8 @@ Examples:
     $ tmsu untag --tags="river underwater year=2014" forest.jpg desert.jpg`,
 	Options: Options{{"--all", "-a", "strip each file of all tags", false, ""},
 		{"--tags", "-t", "the set of tags to remove", true, ""},
-		{"--recursive", "-r", "recursively remove tags from directory contents", false, ""}},
+		{"--recursive", "-r", "recursively remove tags from directory contents", false, ""},
+		{"--continue-on-error", "-C", "apply the successful changes despite errors", false, ""}},
 	Exec: untagExec,
 }
 
@@ -51,6 +53,7 @@ func untagExec(options Options, args []string) error {
 	}
 
 	recursive := options.HasOption("--recursive")
+	continueOnError := options.HasOption("--continue-on-error")
 
 	if options.HasOption("--all") {
 		if len(args) < 1 {
@@ -59,11 +62,14 @@ func untagExec(options Options, args []string) error {
 
 		paths := args
 
-		if err := untagPathsAll(paths, recursive); err != nil {
+		if err := untagPathsAll(paths, recursive, continueOnError); err != nil {
 			return err
 		}
 	} else if options.HasOption("--tags") {
-		tagArgs := strings.Fields(options.Get("--tags").Argument)
+		tagArgs, err := query.SplitTags(options.Get("--tags").Argument)
+		if err != nil {
+			return fmt.Errorf("could not parse tags: %v", err)
+		}
 		if len(tagArgs) == 0 {
 			return fmt.Errorf("set of tags to apply must be specified")
 		}
@@ -73,7 +79,7 @@ func untagExec(options Options, args []string) error {
 			return fmt.Errorf("at least one file to untag must be specified")
 		}
 
-		if err := untagPaths(paths, tagArgs, recursive); err != nil {
+		if err := untagPaths(paths, tagArgs, recursive, continueOnError); err != nil {
 			return err
 		}
 	} else {
@@ -82,9 +88,12 @@ func untagExec(options Options, args []string) error {
 		}
 
 		paths := args[0:1]
-		tagArgs := args[1:]
+		tagArgs, err := unquoteTagArgs(args[1:])
+		if err != nil {
+			return fmt.Errorf("could not parse tags: %v", err)
+		}
 
-		if err := untagPaths(paths, tagArgs, recursive); err != nil {
+		if err := untagPaths(paths, tagArgs, recursive, continueOnError); err != nil {
 			return err
 		}
 	}
@@ -92,13 +101,17 @@ func untagExec(options Options, args []string) error {
 	return nil
 }
 
-func untagPathsAll(paths []string, recursive bool) error {
+func untagPathsAll(paths []string, recursive, continueOnError bool) error {
 	store, err := storage.Open()
 	if err != nil {
 		return fmt.Errorf("could not open storage: %v", err)
 	}
 	defer store.Close()
-	defer store.Commit()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
 
 	wereErrors := false
 	for _, path := range paths {
@@ -137,20 +150,20 @@ func untagPathsAll(paths []string, recursive bool) error {
 		}
 	}
 
-	if wereErrors {
-		return blankError
-	}
-
-	return nil
+	return commitChanges(store, wereErrors, continueOnError)
 }
 
-func untagPaths(paths, tagArgs []string, recursive bool) error {
+func untagPaths(paths, tagArgs []string, recursive, continueOnError bool) error {
 	store, err := storage.Open()
 	if err != nil {
 		return fmt.Errorf("could not open storage: %v", err)
 	}
 	defer store.Close()
-	defer store.Commit()
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	defer store.Rollback()
 
 	tagValuePairs := make([]TagValuePair, 0, 10)
 	wereErrors := false
@@ -231,9 +244,5 @@ func untagPaths(paths, tagArgs []string, recursive bool) error {
 		}
 	}
 
-	if wereErrors {
-		return blankError
-	}
-
-	return nil
+	return commitChanges(store, wereErrors, continueOnError)
 }
@@ -22,6 +22,7 @@ import (
 	"strings"
 	"tmsu/common/format"
 	"tmsu/common/log"
+	"tmsu/query"
 	"tmsu/storage"
 )
 
@@ -91,12 +92,12 @@ func listAllValues(showCount, onePerLine bool) error {
 
 		if onePerLine {
 			for _, value := range values {
-				fmt.Println(value.Name)
+				fmt.Println(query.Quote(value.Name))
 			}
 		} else {
 			valueNames := make([]string, len(values))
 			for index, value := range values {
-				valueNames[index] = value.Name
+				valueNames[index] = query.Quote(value.Name)
 			}
 
 			format.Columns(valueNames, terminalWidth())
@@ -146,12 +147,12 @@ func listValuesForTag(store *storage.Storage, tagName string, showCount, onePerL
 	} else {
 		if onePerLine {
 			for _, value := range values {
-				fmt.Println(value.Name)
+				fmt.Println(query.Quote(value.Name))
 			}
 		} else {
 			valueNames := make([]string, len(values))
 			for index, value := range values {
-				valueNames[index] = value.Name
+				valueNames[index] = query.Quote(value.Name)
 			}
 
 			format.Columns(valueNames, terminalWidth())
@@ -187,13 +188,13 @@ func listValuesForTags(store *storage.Storage, tagNames []string, showCount, one
 			if onePerLine {
 				fmt.Println(tagName)
 				for _, value := range values {
-					fmt.Println(value.Name)
+					fmt.Println(query.Quote(value.Name))
 				}
 				fmt.Println()
 			} else {
 				valueNames := make([]string, len(values))
 				for index, value := range values {
-					valueNames[index] = value.Name
+					valueNames[index] = query.Quote(value.Name)
 				}
 
 				fmt.Printf("%v: %v\n", tagName, strings.Join(valueNames, " "))
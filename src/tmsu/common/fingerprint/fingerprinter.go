@@ -32,6 +32,20 @@ import (
 const sparseFingerprintThreshold = 5 * 1024 * 1024
 const sparseFingerprintSize = 512 * 1024
 
+// The set of supported fingerprint algorithms.
+var Algorithms = []string{"dynamic:SHA256", "dynamic:SHA1", "dynamic:MD5", "SHA256", "SHA1", "MD5", "symlinkTargetName", "symlinkTargetNameNoExt"}
+
+// Determines whether the specified fingerprint algorithm is supported.
+func IsSupported(fingerprintAlgorithm string) bool {
+	for _, algorithm := range Algorithms {
+		if algorithm == fingerprintAlgorithm {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Create a fingerprint using the specified algorithm.
 func Create(path, fingerprintAlgorithm string) (Fingerprint, error) {
 	switch fingerprintAlgorithm {
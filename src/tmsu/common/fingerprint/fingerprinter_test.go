@@ -46,3 +46,28 @@ func TestRegularGeneration(test *testing.T) {
 		test.Fatal("Fingerprint incorrect.")
 	}
 }
+
+func TestSupportedAlgorithms(test *testing.T) {
+	tempFilePath := filepath.Join(os.TempDir(), "tmsu-fingerprint")
+
+	file, err := os.Create(tempFilePath)
+	if err != nil {
+		test.Fatal(err.Error())
+	}
+	defer os.Remove(tempFilePath)
+
+	_, err = file.WriteString("They were the footprints of a giagantic hound.")
+	if err != nil {
+		test.Fatal(err.Error())
+	}
+
+	for _, algorithm := range Algorithms {
+		if _, err := Create(tempFilePath, algorithm); err != nil {
+			test.Fatalf("Algorithm '%v' is listed but not supported: %v", algorithm, err)
+		}
+	}
+
+	if IsSupported("CRC32") {
+		test.Fatal("Unsupported algorithm reported as supported.")
+	}
+}
@@ -18,12 +18,15 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package proc
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
+	"syscall"
 )
 
 type Process struct {
@@ -87,3 +90,136 @@ func GetProcess(pid int) (*Process, error) {
 
 	return &Process{pid, commandLine, workingDirectory}, nil
 }
+
+// Retrieves the processes, other than this one, that have the specified file
+// open. Processes that cannot be inspected are skipped.
+func GetProcessesWithOpenFile(path string) ([]*Process, error) {
+	pids, err := GetProcessIds()
+	if err != nil {
+		return nil, err
+	}
+
+	processes := make([]*Process, 0, 1)
+	for _, pid := range pids {
+		if pid == os.Getpid() || !hasOpenFile(pid, path) {
+			continue
+		}
+
+		process, err := GetProcess(pid)
+		if err != nil {
+			continue
+		}
+
+		processes = append(processes, process)
+	}
+
+	return processes, nil
+}
+
+// A POSIX or flock lock on a file.
+type FileLock struct {
+	Pid   int
+	Write bool
+}
+
+// Retrieves the locks held on the specified file, as listed in /proc/locks.
+// Requests waiting for a lock and locks not associated with a process, such as
+// open file description locks, are not included.
+func GetFileLocks(path string) ([]FileLock, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open("/proc/locks")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	locks := make([]FileLock, 0, 1)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lock, major, minor, inode, ok := parseLockLine(scanner.Text())
+		if !ok || inode != stat.Ino || major != deviceMajor(uint64(stat.Dev)) || minor != deviceMinor(uint64(stat.Dev)) {
+			continue
+		}
+
+		locks = append(locks, lock)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return locks, nil
+}
+
+// unexported
+
+// Parses a line of /proc/locks, e.g.
+// '1: POSIX  ADVISORY  WRITE 1234 08:01:5678 1073741824 1073742335'.
+func parseLockLine(line string) (FileLock, uint64, uint64, uint64, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 6 || fields[1] == "->" {
+		return FileLock{}, 0, 0, 0, false
+	}
+
+	pid, err := strconv.Atoi(fields[4])
+	if err != nil || pid <= 0 {
+		return FileLock{}, 0, 0, 0, false
+	}
+
+	device := strings.Split(fields[5], ":")
+	if len(device) != 3 {
+		return FileLock{}, 0, 0, 0, false
+	}
+
+	major, err := strconv.ParseUint(device[0], 16, 32)
+	if err != nil {
+		return FileLock{}, 0, 0, 0, false
+	}
+	minor, err := strconv.ParseUint(device[1], 16, 32)
+	if err != nil {
+		return FileLock{}, 0, 0, 0, false
+	}
+	inode, err := strconv.ParseUint(device[2], 10, 64)
+	if err != nil {
+		return FileLock{}, 0, 0, 0, false
+	}
+
+	return FileLock{pid, fields[3] == "WRITE"}, major, minor, inode, true
+}
+
+// The major and minor numbers of a device as encoded by glibc.
+func deviceMajor(device uint64) uint64 {
+	return (device&0x00000000000fff00)>>8 | (device&0xfffff00000000000)>>32
+}
+
+func deviceMinor(device uint64) uint64 {
+	return device&0x00000000000000ff | (device&0x00000ffffff00000)>>12
+}
+
+func hasOpenFile(pid int, path string) bool {
+	fdDir := fmt.Sprintf("/proc/%v/fd", pid)
+
+	dir, err := os.Open(fdDir)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+
+	fdNames, err := dir.Readdirnames(0)
+	if err != nil {
+		return false
+	}
+
+	for _, fdName := range fdNames {
+		target, err := os.Readlink(fdDir + "/" + fdName)
+		if err == nil && target == path {
+			return true
+		}
+	}
+
+	return false
+}
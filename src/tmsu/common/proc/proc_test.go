@@ -0,0 +1,72 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestParseLockLine(test *testing.T) {
+	for line, expected := range map[string]struct {
+		lock                FileLock
+		major, minor, inode uint64
+		ok                  bool
+	}{
+		"1: POSIX  ADVISORY  WRITE 1234 08:01:5678 1073741824 1073742335": {FileLock{1234, true}, 8, 1, 5678, true},
+		"2: POSIX  ADVISORY  READ 99 fd:0a:42 128 128":                    {FileLock{99, false}, 0xfd, 0x0a, 42, true},
+		"2: -> POSIX  ADVISORY  WRITE 100 fd:0a:42 0 EOF":                 {FileLock{}, 0, 0, 0, false},
+		"3: OFDLCK ADVISORY  READ  -1 00:06:1028 0 EOF":                   {FileLock{}, 0, 0, 0, false},
+	} {
+		lock, major, minor, inode, ok := parseLockLine(line)
+		if lock != expected.lock || major != expected.major || minor != expected.minor || inode != expected.inode || ok != expected.ok {
+			test.Fatalf("Unexpected result for '%v': %v %v %v %v %v.", line, lock, major, minor, inode, ok)
+		}
+	}
+}
+
+func TestGetFileLocks(test *testing.T) {
+	// set-up
+
+	file, err := ioutil.TempFile("", "tmsu-lock-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: 0, Start: 0, Len: 1}
+	if err := syscall.FcntlFlock(file.Fd(), syscall.F_SETLK, &lock); err != nil {
+		test.Skipf("could not lock file: %v", err)
+	}
+
+	// test
+
+	locks, err := GetFileLocks(file.Name())
+	if err != nil {
+		test.Skipf("could not read locks: %v", err)
+	}
+
+	// validate
+
+	if len(locks) != 1 || locks[0] != (FileLock{os.Getpid(), true}) {
+		test.Fatalf("Unexpected locks %v.", locks)
+	}
+}
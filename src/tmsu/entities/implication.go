@@ -0,0 +1,41 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package entities
+
+type Implication struct {
+	ImplyingTag Tag
+	ImpliedTag  Tag
+}
+
+type Implications []*Implication
+
+func (implications Implications) Len() int {
+	return len(implications)
+}
+
+func (implications Implications) Swap(i, j int) {
+	implications[i], implications[j] = implications[j], implications[i]
+}
+
+func (implications Implications) Less(i, j int) bool {
+	if implications[i].ImplyingTag.Name == implications[j].ImplyingTag.Name {
+		return implications[i].ImpliedTag.Name < implications[j].ImpliedTag.Name
+	}
+
+	return implications[i].ImplyingTag.Name < implications[j].ImplyingTag.Name
+}
@@ -19,6 +19,7 @@ package entities
 
 type Query struct {
 	Text string
+	Name string
 }
 
 type Queries []*Query
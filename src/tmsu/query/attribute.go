@@ -0,0 +1,165 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File attributes are queried using the same syntax as tag values, e.g.
+// 'size>100M'. A tag that shares a name with an attribute can still be compared
+// by escaping its name with a backslash, e.g. '\size>3'.
+
+// The operators supported by each of the file attributes.
+var attributeOperators = map[string][]string{
+	"size":  []string{"=", "!=", "<", ">", "<=", ">="},
+	"mtime": []string{"=", "!=", "<", ">", "<=", ">="},
+	"name":  []string{"=", "!=", "~", "~="},
+	"dir":   []string{"=", "!=", "~", "~="},
+	"type":  []string{"=", "!="},
+}
+
+// Escapes the names of compared tags that share a name with a file attribute,
+// e.g. 'size=3' becomes '\size=3', so that query text written before file
+// attributes were introduced keeps its meaning. Only names for which isTag
+// returns true are escaped.
+func EscapeAttributeTags(text string, isTag func(name string) bool) (string, error) {
+	scanner := NewScanner(text)
+
+	offsets := make([]int, 0, 1)
+	var previous Token
+	for {
+		token, err := scanner.Next()
+		if err != nil {
+			return text, err
+		}
+		if _, ok := token.(EndToken); ok {
+			break
+		}
+
+		if _, ok := token.(ComparisonOperatorToken); ok {
+			if symbol, ok := previous.(SymbolToken); ok && !symbol.escaped && IsAttributeName(symbol.name) && isTag(symbol.name) {
+				offsets = append(offsets, symbol.Offset())
+			}
+		}
+
+		previous = token
+	}
+
+	escaped := ""
+	start := 0
+	for _, offset := range offsets {
+		escaped += text[start:offset] + "\\"
+		start = offset
+	}
+
+	return escaped + text[start:], nil
+}
+
+// Determines whether the name is that of a file attribute.
+func IsAttributeName(name string) bool {
+	_, ok := attributeOperators[name]
+	return ok
+}
+
+// Parses a file size, which may have a K, M, G or T suffix for the respective
+// power of 1024, e.g. '100M'.
+func ParseSize(text string) (int64, error) {
+	multiplier := int64(1)
+
+	if text != "" {
+		switch strings.ToUpper(text[len(text)-1:]) {
+		case "K":
+			multiplier = 1 << 10
+		case "M":
+			multiplier = 1 << 20
+		case "G":
+			multiplier = 1 << 30
+		case "T":
+			multiplier = 1 << 40
+		}
+	}
+
+	if multiplier != 1 {
+		text = text[:len(text)-1]
+	}
+
+	size, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size '%v': expected a number of bytes, optionally suffixed with K, M, G or T.", text)
+	}
+
+	return size * multiplier, nil
+}
+
+var timeLayouts = []struct {
+	layout string
+	next   func(time.Time) time.Time
+}{
+	{"2006-01-02T15:04:05", func(t time.Time) time.Time { return t.Add(time.Second) }},
+	{"2006-01-02T15:04", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+	{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+	{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+}
+
+// Parses a local time, which may be partial, e.g. '2014-06', returning the start
+// of the period it represents and the start of the following period.
+func ParseTime(text string) (time.Time, time.Time, error) {
+	for _, timeLayout := range timeLayouts {
+		start, err := time.ParseInLocation(timeLayout.layout, text, time.Local)
+		if err == nil {
+			return start, timeLayout.next(start), nil
+		}
+	}
+
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid time '%v': expected e.g. '2014', '2014-06', '2014-06-30' or '2014-06-30T12:00'.", text)
+}
+
+// unexported
+
+func validateAttribute(name, operator, value string) error {
+	supported := false
+	for _, attributeOperator := range attributeOperators[name] {
+		if attributeOperator == operator {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return fmt.Errorf("operator '%v' is not supported for file attribute '%v'.", operator, name)
+	}
+
+	switch name {
+	case "size":
+		_, err := ParseSize(value)
+		return err
+	case "mtime":
+		_, _, err := ParseTime(value)
+		return err
+	case "type":
+		if value != "file" && value != "dir" {
+			return fmt.Errorf("invalid type '%v': expected 'file' or 'dir'.", value)
+		}
+	}
+
+	return nil
+}
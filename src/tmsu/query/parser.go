@@ -19,6 +19,8 @@ package query
 
 import (
 	"fmt"
+	"strings"
+	"unicode/utf8"
 )
 
 type Parser struct {
@@ -33,12 +35,36 @@ func (parser Parser) Parse() (Expression, error) {
 	return parser.expression()
 }
 
+// An error in the query text. The error message shows the query with a caret
+// under the problem, e.g.:
+//
+//	unexpected token: ')'.
+//	    a and )
+//	          ^
+type ParseError struct {
+	Query   string
+	Offset  int
+	Message string
+}
+
+func (err *ParseError) Error() string {
+	column := utf8.RuneCountInString(err.Query[:err.Offset])
+	return fmt.Sprintf("%v\n    %v\n    %v^", err.Message, err.Query, strings.Repeat(" ", column))
+}
+
 type Expression interface {
+	String() string
 }
 
 type EmptyExpression struct {
 }
 
+// An expression that matches either all files or none. Constants result from
+// simplifying an expression.
+type ConstantExpression struct {
+	Value bool
+}
+
 type OrExpression struct {
 	LeftOperand  Expression
 	RightOperand Expression
@@ -55,6 +81,30 @@ type ComparisonExpression struct {
 	Value    ValueExpression
 }
 
+// A comparison of a tag's values against a range, e.g. 'year=2000..2010'. The
+// range includes both bounds.
+type RangeExpression struct {
+	Tag      TagExpression
+	Operator string
+	From     ValueExpression
+	To       ValueExpression
+}
+
+// A comparison of a tag's values against a set of values, e.g.
+// 'country=(fr,de,it)'.
+type SetExpression struct {
+	Tag      TagExpression
+	Operator string
+	Values   []ValueExpression
+}
+
+// A comparison against one of the file's attributes, e.g. 'size>100M'.
+type AttributeExpression struct {
+	Name     string
+	Operator string
+	Value    ValueExpression
+}
+
 type NotExpression struct {
 	Operand Expression
 }
@@ -63,10 +113,21 @@ type TagExpression struct {
 	Name string
 }
 
+// A pattern that matches tag names, e.g. 'genre-*'. A '*' matches any sequence
+// of characters and a '?' any single character.
+type TagPatternExpression struct {
+	Pattern string
+}
+
 type ValueExpression struct {
 	Name string
 }
 
+// A reference to a named query, e.g. '@holiday-photos'.
+type MacroExpression struct {
+	Name string
+}
+
 // unexported
 
 func (parser Parser) expression() (Expression, error) {
@@ -80,7 +141,7 @@ func (parser Parser) expression() (Expression, error) {
 	case EndToken:
 		return expression, nil
 	default:
-		return nil, fmt.Errorf("unexpected token: %v.", Type(token))
+		return nil, parser.unexpected(token)
 	}
 }
 
@@ -108,7 +169,7 @@ func (parser Parser) or() (Expression, error) {
 		case EndToken, CloseParenToken:
 			return leftOperand, nil
 		default:
-			return nil, fmt.Errorf("unexpected token: %v.", Type(token))
+			return nil, parser.unexpected(token)
 		}
 	}
 }
@@ -136,7 +197,7 @@ func (parser Parser) and() (Expression, error) {
 			leftOperand = AndExpression{leftOperand, rightOperand}
 		case OrOperatorToken, CloseParenToken, EndToken:
 			return leftOperand, nil
-		case NotOperatorToken, SymbolToken, OpenParenToken:
+		case NotOperatorToken, SymbolToken, MacroToken, OpenParenToken:
 			rightOperand, err := parser.not()
 			if err != nil {
 				return nil, err
@@ -144,7 +205,7 @@ func (parser Parser) and() (Expression, error) {
 
 			leftOperand = AndExpression{leftOperand, rightOperand}
 		default:
-			return nil, fmt.Errorf("unexpected token: %v.", Type(token))
+			return nil, parser.unexpected(token)
 		}
 	}
 }
@@ -155,7 +216,7 @@ func (parser Parser) not() (Expression, error) {
 		return nil, err
 	}
 
-	switch token.(type) {
+	switch typedToken := token.(type) {
 	case NotOperatorToken:
 		parser.scanner.Next()
 
@@ -182,7 +243,7 @@ func (parser Parser) not() (Expression, error) {
 		case CloseParenToken:
 			return operand, nil
 		default:
-			return nil, fmt.Errorf("unexpected token: %v", Type(token2))
+			return nil, parser.unexpected(token2)
 		}
 	case SymbolToken:
 		operand, err := parser.comparison()
@@ -191,12 +252,21 @@ func (parser Parser) not() (Expression, error) {
 		}
 
 		return operand, nil
+	case MacroToken:
+		parser.scanner.Next()
+
+		return MacroExpression{typedToken.name}, nil
 	default:
-		return nil, fmt.Errorf("unexpected token: %v.", Type(token))
+		return nil, parser.unexpected(token)
 	}
 }
 
 func (parser Parser) comparison() (Expression, error) {
+	symbol, err := parser.scanner.LookAhead()
+	if err != nil {
+		return nil, err
+	}
+
 	tag, err := parser.tag()
 	if err != nil {
 		return nil, err
@@ -207,21 +277,166 @@ func (parser Parser) comparison() (Expression, error) {
 		return nil, err
 	}
 
+	// a quoted or escaped tag name is literal: neither a pattern nor an attribute
+	escaped := symbol.(SymbolToken).escaped
+
 	switch typedToken := token.(type) {
 	case ComparisonOperatorToken:
+		if !escaped && IsTagPattern(tag.Name) {
+			return nil, parser.errorf(typedToken, "the values of tag name pattern '%v' cannot be compared.", tag.Name)
+		}
+
 		parser.scanner.Next()
 
+		isAttribute := IsAttributeName(tag.Name) && !escaped
+
+		token, err := parser.scanner.LookAhead()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := token.(OpenParenToken); ok {
+			return parser.set(tag, typedToken, isAttribute)
+		}
+
+		valueToken := token
 		value, err := parser.value()
 		if err != nil {
 			return nil, err
 		}
 
+		token, err = parser.scanner.LookAhead()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := token.(RangeOperatorToken); ok {
+			return parser.valueRange(tag, typedToken, value, valueToken, isAttribute)
+		}
+
+		if isAttribute {
+			if err := validateAttribute(tag.Name, typedToken.operator, value.Name); err != nil {
+				return nil, parser.errorf(valueToken, "%v", err)
+			}
+
+			return AttributeExpression{tag.Name, typedToken.operator, value}, nil
+		}
+
 		return ComparisonExpression{tag, typedToken.operator, value}, nil
 	}
 
+	if !escaped && IsTagPattern(tag.Name) {
+		return TagPatternExpression{tag.Name}, nil
+	}
+
 	return tag, nil
 }
 
+// Parses the upper bound of a range, e.g. the '..2010' of 'year=2000..2010'.
+// Ranges of file attribute values are rewritten as a pair of comparisons.
+func (parser Parser) valueRange(tag TagExpression, operatorToken ComparisonOperatorToken, from ValueExpression, fromToken Token, isAttribute bool) (Expression, error) {
+	parser.scanner.Next()
+
+	toToken, err := parser.scanner.LookAhead()
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := parser.value()
+	if err != nil {
+		return nil, err
+	}
+
+	operator := operatorToken.operator
+	if operator != "=" && operator != "!=" {
+		return nil, parser.errorf(operatorToken, "operator '%v' cannot be used with a range: use '=' or '!='.", operator)
+	}
+
+	if !isAttribute {
+		return RangeExpression{tag, operator, from, to}, nil
+	}
+
+	if err := validateAttribute(tag.Name, ">=", from.Name); err != nil {
+		return nil, parser.errorf(fromToken, "invalid range for file attribute '%v': %v", tag.Name, err)
+	}
+	if err := validateAttribute(tag.Name, "<=", to.Name); err != nil {
+		return nil, parser.errorf(toToken, "invalid range for file attribute '%v': %v", tag.Name, err)
+	}
+
+	var expression Expression = AndExpression{AttributeExpression{tag.Name, ">=", from}, AttributeExpression{tag.Name, "<=", to}}
+	if operator == "!=" {
+		expression = NotExpression{expression}
+	}
+
+	return expression, nil
+}
+
+// Parses a parenthesised, comma separated set of values, e.g. '(fr,de,it)'.
+// Sets of file attribute values are rewritten as alternative comparisons.
+func (parser Parser) set(tag TagExpression, operatorToken ComparisonOperatorToken, isAttribute bool) (Expression, error) {
+	parser.scanner.Next()
+
+	values := make([]ValueExpression, 0, 10)
+	valueTokens := make([]Token, 0, 10)
+	stop := false
+	for !stop {
+		valueToken, err := parser.scanner.LookAhead()
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := parser.value()
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+		valueTokens = append(valueTokens, valueToken)
+
+		token, err := parser.scanner.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch token.(type) {
+		case CommaToken:
+			// more values follow
+		case CloseParenToken:
+			stop = true
+		default:
+			return nil, parser.unexpected(token)
+		}
+	}
+
+	operator := operatorToken.operator
+	if operator != "=" && operator != "!=" {
+		return nil, parser.errorf(operatorToken, "operator '%v' cannot be used with a set of values: use '=' or '!='.", operator)
+	}
+
+	if !isAttribute {
+		return SetExpression{tag, operator, values}, nil
+	}
+
+	var expression Expression
+	for index, value := range values {
+		if err := validateAttribute(tag.Name, "=", value.Name); err != nil {
+			return nil, parser.errorf(valueTokens[index], "%v", err)
+		}
+
+		if expression == nil {
+			expression = AttributeExpression{tag.Name, "=", value}
+		} else {
+			expression = OrExpression{expression, AttributeExpression{tag.Name, "=", value}}
+		}
+	}
+
+	if operator == "!=" {
+		expression = NotExpression{expression}
+	}
+
+	return expression, nil
+}
+
 func (parser Parser) tag() (TagExpression, error) {
 	token, err := parser.scanner.Next()
 	if err != nil {
@@ -232,7 +447,7 @@ func (parser Parser) tag() (TagExpression, error) {
 	case SymbolToken:
 		return TagExpression{typedToken.name}, nil
 	default:
-		return TagExpression{}, fmt.Errorf("unexpected token: %v.", Type(token))
+		return TagExpression{}, parser.unexpected(token)
 	}
 }
 
@@ -246,6 +461,14 @@ func (parser Parser) value() (ValueExpression, error) {
 	case SymbolToken:
 		return ValueExpression{typedToken.name}, nil
 	default:
-		return ValueExpression{}, fmt.Errorf("unexpected token: %v", Type(token))
+		return ValueExpression{}, parser.unexpected(token)
 	}
 }
+
+func (parser Parser) errorf(token Token, format string, args ...interface{}) error {
+	return parser.scanner.errorf(token.Offset(), format, args...)
+}
+
+func (parser Parser) unexpected(token Token) error {
+	return parser.errorf(token, "unexpected token: %v.", Type(token))
+}
@@ -116,6 +116,54 @@ func TestTagLessThanOrEqualValueParsing(test *testing.T) {
 	validateValue(comparison.Value, "2000", test)
 }
 
+func TestTagNotEqualValueParsing(test *testing.T) {
+	scanner := NewScanner("country!=uk")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	comparison := validateComparison(expression, "!=", test)
+	validateTag(comparison.Tag, "country", test)
+	validateValue(comparison.Value, "uk", test)
+}
+
+func TestTagGlobValueParsing(test *testing.T) {
+	scanner := NewScanner("artist~The*")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	comparison := validateComparison(expression, "~", test)
+	validateTag(comparison.Tag, "artist", test)
+	validateValue(comparison.Value, "The*", test)
+}
+
+func TestTagCaseInsensitiveEqualValueParsing(test *testing.T) {
+	scanner := NewScanner("artist~=beatles")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	comparison := validateComparison(expression, "~=", test)
+	validateTag(comparison.Tag, "artist", test)
+	validateValue(comparison.Value, "beatles", test)
+}
+
 func TestNotParsing(test *testing.T) {
 	scanner := NewScanner("not cheese")
 	parser := NewParser(scanner)
@@ -379,8 +427,239 @@ func TestAndParenOrParsing(test *testing.T) {
 	validateTag(or.RightOperand, "sweetcorn", test)
 }
 
+func TestMacroParsing(test *testing.T) {
+	scanner := NewScanner("@holiday-photos not blurry")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	validateMacro(and.LeftOperand, "holiday-photos", test)
+	not := validateNot(and.RightOperand)
+	validateTag(not.Operand, "blurry", test)
+}
+
+func TestAttributeParsing(test *testing.T) {
+	scanner := NewScanner("size>100M and name~*.jpg and not type=dir")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	innerAnd := validateAnd(and.LeftOperand)
+	validateAttributeExpression(innerAnd.LeftOperand, "size", ">", "100M", test)
+	validateAttributeExpression(innerAnd.RightOperand, "name", "~", "*.jpg", test)
+	not := validateNot(and.RightOperand)
+	validateAttributeExpression(not.Operand, "type", "=", "dir", test)
+}
+
+func TestEscapedAttributeNameParsing(test *testing.T) {
+	scanner := NewScanner("\\size>3 and size")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	comparison := validateComparison(and.LeftOperand, ">", test)
+	validateTag(comparison.Tag, "size", test)
+	validateValue(comparison.Value, "3", test)
+	validateTag(and.RightOperand, "size", test)
+}
+
+func TestInvalidAttributeParsing(test *testing.T) {
+	for _, text := range []string{"size>lots", "mtime<yesterday", "type=link", "size~100", "name<a"} {
+		if _, err := NewParser(NewScanner(text)).Parse(); err == nil {
+			test.Fatalf("Invalid query '%v' was accepted.", text)
+		}
+	}
+}
+
+func TestRangeParsing(test *testing.T) {
+	scanner := NewScanner("year=2000..2010")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	valueRange := expression.(RangeExpression)
+	if valueRange.Operator != "=" {
+		test.Fatalf("Expected '=' operator but was '%v'.", valueRange.Operator)
+	}
+	validateTag(valueRange.Tag, "year", test)
+	validateValue(valueRange.From, "2000", test)
+	validateValue(valueRange.To, "2010", test)
+}
+
+func TestSetParsing(test *testing.T) {
+	scanner := NewScanner("country!=(fr,de,it) and music")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	set := and.LeftOperand.(SetExpression)
+	if set.Operator != "!=" {
+		test.Fatalf("Expected '!=' operator but was '%v'.", set.Operator)
+	}
+	validateTag(set.Tag, "country", test)
+	if len(set.Values) != 3 {
+		test.Fatalf("Expected 3 values but was %v.", len(set.Values))
+	}
+	validateValue(set.Values[0], "fr", test)
+	validateValue(set.Values[1], "de", test)
+	validateValue(set.Values[2], "it", test)
+	validateTag(and.RightOperand, "music", test)
+}
+
+func TestAttributeRangeAndSetParsing(test *testing.T) {
+	scanner := NewScanner("size=1M..10M type!=(dir)")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	sizeRange := validateAnd(and.LeftOperand)
+	validateAttributeExpression(sizeRange.LeftOperand, "size", ">=", "1M", test)
+	validateAttributeExpression(sizeRange.RightOperand, "size", "<=", "10M", test)
+	not := validateNot(and.RightOperand)
+	validateAttributeExpression(not.Operand, "type", "=", "dir", test)
+}
+
+func TestInvalidRangeAndSetParsing(test *testing.T) {
+	for _, text := range []string{"year<2000..2010", "year=2000..", "country~(fr,de)", "country=(fr,de", "country=(fr,)", "country=()", "name=a..b"} {
+		if _, err := NewParser(NewScanner(text)).Parse(); err == nil {
+			test.Fatalf("Invalid query '%v' was accepted.", text)
+		}
+	}
+}
+
+func TestTagPatternParsing(test *testing.T) {
+	scanner := NewScanner("genre-* and not camera-?ikon")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	validateTagPattern(and.LeftOperand, "genre-*", test)
+	not := validateNot(and.RightOperand)
+	validateTagPattern(not.Operand, "camera-?ikon", test)
+}
+
+func TestTagPatternComparisonParsing(test *testing.T) {
+	if _, err := NewParser(NewScanner("genre-*=rock")).Parse(); err == nil {
+		test.Fatal("Comparison of a tag name pattern was accepted.")
+	}
+}
+
+func TestEscapedTagPatternParsing(test *testing.T) {
+	expression, err := NewParser(NewScanner(`genre-\* and not "camera-?"=nikon`)).Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	validateTag(and.LeftOperand, "genre-*", test)
+	not := validateNot(and.RightOperand)
+	comparison := validateComparison(not.Operand, "=", test)
+	validateTag(comparison.Tag, "camera-?", test)
+	validateValue(comparison.Value, "nikon", test)
+}
+
 // unexported
 
+func TestParseErrorOffset(test *testing.T) {
+	offsets := map[string]int{"a and )": 6, "(a or b": 7, "size>lots": 5, "year<2000..2010": 4, "name=(x,y,z) and mtime=(2014,later)": 29, "café and )": 10}
+
+	for text, expected := range offsets {
+		_, err := NewParser(NewScanner(text)).Parse()
+		parseError, ok := err.(*ParseError)
+		if !ok {
+			test.Fatalf("Expected parse error for '%v' but was '%v'.", text, err)
+		}
+		if parseError.Offset != expected {
+			test.Fatalf("Expected error in '%v' at offset %v but was %v.", text, expected, parseError.Offset)
+		}
+	}
+}
+
+func TestParseErrorCaret(test *testing.T) {
+	_, err := NewParser(NewScanner("café and )")).Parse()
+	if err == nil {
+		test.Fatal("Invalid query was accepted.")
+	}
+
+	expected := "unexpected token: ')'.\n    café and )\n             ^"
+	if err.Error() != expected {
+		test.Fatalf("Expected error '%v' but was '%v'.", expected, err.Error())
+	}
+}
+
+func validateTagPattern(expression Expression, expectedPattern string, test *testing.T) TagPatternExpression {
+	pattern, ok := expression.(TagPatternExpression)
+	if !ok {
+		test.Fatalf("Expected tag pattern but was '%v'.", expression)
+	}
+	if pattern.Pattern != expectedPattern {
+		test.Fatalf("Expected '%v' tag pattern but was '%v'.", expectedPattern, pattern.Pattern)
+	}
+
+	return pattern
+}
+
+func validateAttributeExpression(expression Expression, expectedName, expectedOperator, expectedValue string, test *testing.T) AttributeExpression {
+	attribute := expression.(AttributeExpression)
+	if attribute.Name != expectedName || attribute.Operator != expectedOperator || attribute.Value.Name != expectedValue {
+		test.Fatalf("Expected attribute '%v%v%v' but was '%v%v%v'.", expectedName, expectedOperator, expectedValue, attribute.Name, attribute.Operator, attribute.Value.Name)
+	}
+
+	return attribute
+}
+
+func validateMacro(expression Expression, expectedName string, test *testing.T) MacroExpression {
+	macro := expression.(MacroExpression)
+	if macro.Name != expectedName {
+		test.Fatalf("Expected '%v' macro but was '%v'.", expectedName, macro.Name)
+	}
+
+	return macro
+}
+
 func validateNot(expression Expression) NotExpression {
 	return expression.(NotExpression)
 }
@@ -429,6 +708,10 @@ func dumpBranch(expression Expression) {
 	switch exp := expression.(type) {
 	case TagExpression:
 		fmt.Printf(exp.Name)
+	case TagPatternExpression:
+		fmt.Printf("Pattern(%v)", exp.Pattern)
+	case MacroExpression:
+		fmt.Printf("@%v", exp.Name)
 	case NotExpression:
 		fmt.Printf("Not(")
 		dumpBranch(exp.Operand)
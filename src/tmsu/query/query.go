@@ -17,6 +17,11 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
 package query
 
+import (
+	"fmt"
+	"strings"
+)
+
 func Parse(query string) (Expression, error) {
 	scanner := NewScanner(query)
 	parser := NewParser(scanner)
@@ -39,7 +44,19 @@ func HasAll(tagNames []string) Expression {
 	return expression
 }
 
-// Retrieves the set of tag names from an expression
+// Splits an expression into the terms that are combined with 'and', e.g.
+// 'a b=1 (c or d)' into 'a', 'b=1' and 'c or d'. Other expressions are a single
+// term.
+func Terms(expression Expression) []Expression {
+	if and, ok := expression.(AndExpression); ok {
+		return append(Terms(and.LeftOperand), Terms(and.RightOperand)...)
+	}
+
+	return []Expression{expression}
+}
+
+// Retrieves the set of tag names from an expression. Tag name patterns are not
+// included: see TagPatterns.
 func TagNames(expression Expression) []string {
 	names := make([]string, 0, 10)
 	names = tagNames(expression, names)
@@ -47,6 +64,51 @@ func TagNames(expression Expression) []string {
 	return names
 }
 
+// Retrieves the set of tag name patterns from an expression
+func TagPatterns(expression Expression) []string {
+	patterns := make([]string, 0, 10)
+	patterns = tagPatterns(expression, patterns)
+
+	return patterns
+}
+
+// Determines whether a tag name is a pattern, i.e. contains a wildcard.
+func IsTagPattern(name string) bool {
+	return strings.ContainsAny(name, "*?")
+}
+
+// Determines whether a tag name matches a tag name pattern: '*' matches any
+// run of characters, '?' matches any single character and every other
+// character, including '[', matches only itself.
+func MatchTagPattern(pattern, name string) bool {
+	patternRunes := []rune(pattern)
+	nameRunes := []rune(name)
+
+	star, resume := -1, 0
+	p, n := 0, 0
+	for n < len(nameRunes) {
+		switch {
+		case p < len(patternRunes) && patternRunes[p] == '*':
+			star, resume = p, n
+			p++
+		case p < len(patternRunes) && (patternRunes[p] == '?' || patternRunes[p] == nameRunes[n]):
+			p++
+			n++
+		case star != -1:
+			resume++
+			p, n = star+1, resume
+		default:
+			return false
+		}
+	}
+
+	for p < len(patternRunes) && patternRunes[p] == '*' {
+		p++
+	}
+
+	return p == len(patternRunes)
+}
+
 // Retrieves the set of value names from an expression
 func ValueNames(expression Expression) []string {
 	names := make([]string, 0, 10)
@@ -55,8 +117,231 @@ func ValueNames(expression Expression) []string {
 	return names
 }
 
+// The String methods format an expression as canonical query text: operators
+// are lower case, comparisons have no surrounding space and parentheses are
+// added only where needed.
+
+func (exp EmptyExpression) String() string {
+	return ""
+}
+
+func (exp ConstantExpression) String() string {
+	if exp.Value {
+		return "true"
+	}
+
+	return "false"
+}
+
+func (exp TagExpression) String() string {
+	return Quote(exp.Name)
+}
+
+func (exp TagPatternExpression) String() string {
+	return exp.Pattern
+}
+
+func (exp ValueExpression) String() string {
+	return Quote(exp.Name)
+}
+
+func (exp ComparisonExpression) String() string {
+	return formatTagName(exp.Tag.Name) + exp.Operator + exp.Value.String()
+}
+
+func (exp RangeExpression) String() string {
+	return formatTagName(exp.Tag.Name) + exp.Operator + exp.From.String() + ".." + exp.To.String()
+}
+
+func (exp SetExpression) String() string {
+	valueNames := make([]string, len(exp.Values))
+	for index, value := range exp.Values {
+		valueNames[index] = value.String()
+	}
+
+	return formatTagName(exp.Tag.Name) + exp.Operator + "(" + strings.Join(valueNames, ",") + ")"
+}
+
+func (exp AttributeExpression) String() string {
+	return exp.Name + exp.Operator + exp.Value.String()
+}
+
+func (exp MacroExpression) String() string {
+	return "@" + Quote(exp.Name)
+}
+
+func (exp NotExpression) String() string {
+	return "not " + formatOperand(exp.Operand, notPrecedence)
+}
+
+func (exp AndExpression) String() string {
+	return formatOperand(exp.LeftOperand, andPrecedence) + " and " + formatOperand(exp.RightOperand, andPrecedence)
+}
+
+func (exp OrExpression) String() string {
+	return exp.LeftOperand.String() + " or " + exp.RightOperand.String()
+}
+
+// Renames a tag throughout an expression.
+func RenameTag(expression Expression, name, newName string) Expression {
+	switch exp := expression.(type) {
+	case TagExpression:
+		if exp.Name == name {
+			return TagExpression{newName}
+		}
+	case ComparisonExpression:
+		if exp.Tag.Name == name {
+			return ComparisonExpression{TagExpression{newName}, exp.Operator, exp.Value}
+		}
+	case RangeExpression:
+		if exp.Tag.Name == name {
+			return RangeExpression{TagExpression{newName}, exp.Operator, exp.From, exp.To}
+		}
+	case SetExpression:
+		if exp.Tag.Name == name {
+			return SetExpression{TagExpression{newName}, exp.Operator, exp.Values}
+		}
+	case NotExpression:
+		return NotExpression{RenameTag(exp.Operand, name, newName)}
+	case AndExpression:
+		return AndExpression{RenameTag(exp.LeftOperand, name, newName), RenameTag(exp.RightOperand, name, newName)}
+	case OrExpression:
+		return OrExpression{RenameTag(exp.LeftOperand, name, newName), RenameTag(exp.RightOperand, name, newName)}
+	}
+
+	return expression
+}
+
+// Retrieves the set of query names referred to by macros within an expression
+func MacroNames(expression Expression) []string {
+	names := make([]string, 0, 10)
+	names = macroNames(expression, names)
+
+	return names
+}
+
+// Replaces each macro within an expression with the query it names. The lookup
+// function retrieves the text of the named query.
+func ExpandMacros(expression Expression, lookup func(name string) (string, error)) (Expression, error) {
+	return expandMacros(expression, lookup, []string{})
+}
+
 // unexported
 
+const (
+	orPrecedence = iota
+	andPrecedence
+	notPrecedence
+	operandPrecedence
+)
+
+func precedence(expression Expression) int {
+	switch expression.(type) {
+	case OrExpression:
+		return orPrecedence
+	case AndExpression:
+		return andPrecedence
+	case NotExpression:
+		return notPrecedence
+	}
+
+	return operandPrecedence
+}
+
+// Formats a tag name, escaping it if it would otherwise be interpretted as a file
+// attribute and quoting it if it contains special characters.
+func formatTagName(name string) string {
+	if IsAttributeName(name) {
+		return "\\" + name
+	}
+
+	return Quote(name)
+}
+
+// Formats an operand, parenthesising it if it binds less tightly than the
+// operator it belongs to.
+func formatOperand(operand Expression, operatorPrecedence int) string {
+	if precedence(operand) < operatorPrecedence {
+		return "(" + operand.String() + ")"
+	}
+
+	return operand.String()
+}
+
+func expandMacros(expression Expression, lookup func(name string) (string, error), expanding []string) (Expression, error) {
+	switch exp := expression.(type) {
+	case MacroExpression:
+		for index, name := range expanding {
+			if name == exp.Name {
+				cycle := append(expanding[index:], exp.Name)
+				return nil, fmt.Errorf("query '@%v' refers to itself: @%v.", exp.Name, strings.Join(cycle, " -> @"))
+			}
+		}
+
+		text, err := lookup(exp.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		macroExpression, err := Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("query '@%v' is invalid: %v", exp.Name, err)
+		}
+
+		return expandMacros(macroExpression, lookup, append(expanding, exp.Name))
+	case NotExpression:
+		operand, err := expandMacros(exp.Operand, lookup, expanding)
+		if err != nil {
+			return nil, err
+		}
+
+		return NotExpression{operand}, nil
+	case AndExpression:
+		leftOperand, err := expandMacros(exp.LeftOperand, lookup, expanding)
+		if err != nil {
+			return nil, err
+		}
+
+		rightOperand, err := expandMacros(exp.RightOperand, lookup, expanding)
+		if err != nil {
+			return nil, err
+		}
+
+		return AndExpression{leftOperand, rightOperand}, nil
+	case OrExpression:
+		leftOperand, err := expandMacros(exp.LeftOperand, lookup, expanding)
+		if err != nil {
+			return nil, err
+		}
+
+		rightOperand, err := expandMacros(exp.RightOperand, lookup, expanding)
+		if err != nil {
+			return nil, err
+		}
+
+		return OrExpression{leftOperand, rightOperand}, nil
+	}
+
+	return expression, nil
+}
+
+func macroNames(expression Expression, names []string) []string {
+	switch exp := expression.(type) {
+	case MacroExpression:
+		names = append(names, exp.Name)
+	case NotExpression:
+		names = macroNames(exp.Operand, names)
+	case AndExpression:
+		names = macroNames(exp.LeftOperand, names)
+		names = macroNames(exp.RightOperand, names)
+	case OrExpression:
+		names = macroNames(exp.LeftOperand, names)
+		names = macroNames(exp.RightOperand, names)
+	}
+
+	return names
+}
+
 func tagNames(expression Expression, names []string) []string {
 	switch exp := expression.(type) {
 	case TagExpression:
@@ -71,6 +356,12 @@ func tagNames(expression Expression, names []string) []string {
 		names = tagNames(exp.RightOperand, names)
 	case ComparisonExpression:
 		names = append(names, exp.Tag.Name)
+	case RangeExpression:
+		names = append(names, exp.Tag.Name)
+	case SetExpression:
+		names = append(names, exp.Tag.Name)
+	case TagPatternExpression, AttributeExpression, MacroExpression, ConstantExpression, EmptyExpression:
+		// nowt
 	default:
 		panic("unsupported token type")
 	}
@@ -78,9 +369,26 @@ func tagNames(expression Expression, names []string) []string {
 	return names
 }
 
+func tagPatterns(expression Expression, patterns []string) []string {
+	switch exp := expression.(type) {
+	case TagPatternExpression:
+		patterns = append(patterns, exp.Pattern)
+	case NotExpression:
+		patterns = tagPatterns(exp.Operand, patterns)
+	case AndExpression:
+		patterns = tagPatterns(exp.LeftOperand, patterns)
+		patterns = tagPatterns(exp.RightOperand, patterns)
+	case OrExpression:
+		patterns = tagPatterns(exp.LeftOperand, patterns)
+		patterns = tagPatterns(exp.RightOperand, patterns)
+	}
+
+	return patterns
+}
+
 func valueNames(expression Expression, names []string) []string {
 	switch exp := expression.(type) {
-	case TagExpression:
+	case TagExpression, TagPatternExpression:
 		// nowt
 	case NotExpression:
 		names = valueNames(exp.Operand, names)
@@ -92,6 +400,14 @@ func valueNames(expression Expression, names []string) []string {
 		names = valueNames(exp.RightOperand, names)
 	case ComparisonExpression:
 		names = append(names, exp.Value.Name)
+	case RangeExpression:
+		names = append(names, exp.From.Name, exp.To.Name)
+	case SetExpression:
+		for _, value := range exp.Values {
+			names = append(names, value.Name)
+		}
+	case AttributeExpression, MacroExpression, ConstantExpression, EmptyExpression:
+		// nowt
 	default:
 		panic("unsupported token type")
 	}
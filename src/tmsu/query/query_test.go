@@ -0,0 +1,369 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package query
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExpandMacros(test *testing.T) {
+	queries := map[string]string{"holiday": "beach or mountains", "good-holiday": "@holiday and not rain"}
+
+	expression, err := Parse("@good-holiday and 2014")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expression, err = ExpandMacros(expression, lookupIn(queries))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	validateTag(and.RightOperand, "2014", test)
+	innerAnd := validateAnd(and.LeftOperand)
+	or := validateOr(innerAnd.LeftOperand)
+	validateTag(or.LeftOperand, "beach", test)
+	validateTag(or.RightOperand, "mountains", test)
+	not := validateNot(innerAnd.RightOperand)
+	validateTag(not.Operand, "rain", test)
+}
+
+func TestExpandMacrosTwice(test *testing.T) {
+	queries := map[string]string{"music": "mp3 or flac"}
+
+	expression, err := Parse("@music and not (@music and live)")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expression, err = ExpandMacros(expression, lookupIn(queries))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(MacroNames(expression)) != 0 {
+		test.Fatalf("Expected all macros to be expanded.")
+	}
+}
+
+func TestExpandRecursiveMacros(test *testing.T) {
+	queries := map[string]string{"a": "apple and @b", "b": "banana or @c", "c": "@a"}
+
+	expression, err := Parse("cheese and @a")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	_, err = ExpandMacros(expression, lookupIn(queries))
+	if err == nil {
+		test.Fatal("Expected recursive macro to be rejected.")
+	}
+	if !strings.Contains(err.Error(), "@a -> @b -> @c -> @a") {
+		test.Fatalf("Expected error to show the cycle but was: %v", err)
+	}
+}
+
+func TestExpandUnknownMacro(test *testing.T) {
+	expression, err := Parse("@missing")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err = ExpandMacros(expression, lookupIn(map[string]string{})); err == nil {
+		test.Fatal("Expected unknown macro to be rejected.")
+	}
+}
+
+// unexported
+
+func lookupIn(queries map[string]string) func(name string) (string, error) {
+	return func(name string) (string, error) {
+		text, ok := queries[name]
+		if !ok {
+			return "", fmt.Errorf("no such query '@%v'.", name)
+		}
+
+		return text, nil
+	}
+}
+
+func TestString(test *testing.T) {
+	for _, text := range []string{"cheese", "year>=2000", "@holiday", "not cheese", "cheese and tomato or sweetcorn", "size>1M", "\\size=3",
+		"cheese and (tomato or sweetcorn)", "not (cheese or tomato) and not not sweetcorn", "a or b or c", "year=2000..2010", "country!=(fr,de,it)", "\\size=1..3", "genre-* and not b?",
+		`title="Blue Train"`, `"and" or x="a<b"`, `quote="say \"hi\""`, `dir="/my photos" and year=("2000..2010",2011)`} {
+		expression, err := Parse(text)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if formatted := expression.String(); formatted != text {
+			test.Fatalf("Expected '%v' but was '%v'.", text, formatted)
+		}
+	}
+}
+
+func TestQuote(test *testing.T) {
+	for text, expected := range map[string]string{"cheese": "cheese", "Blue Train": `"Blue Train"`, "a=b": `"a=b"`, "not": `"not"`, "": `""`,
+		"1..2": `"1..2"`, `a"b\c`: `"a\"b\\c"`, "@x": `"@x"`, "genre-*": `"genre-*"`} {
+		if quoted := Quote(text); quoted != expected {
+			test.Fatalf("Expected '%v' to be quoted as '%v' but was '%v'.", text, expected, quoted)
+		}
+	}
+}
+
+func TestMatchTagPattern(test *testing.T) {
+	for pattern, names := range map[string]map[string]bool{
+		"genre-*":   {"genre-": true, "genre-rock": true, "genre/rock": false, "genre-a/b": true},
+		"r?ck":      {"rock": true, "róck": true, "rck": false, "frock": false},
+		"*-*-live":  {"a-b-live": true, "a-b-c-live": true, "a-live": false},
+		"live-[1]*": {"live-[1]": true, "live-[1]x": true, "live-1": false},
+		"*":         {"": true, "anything": true}} {
+		for name, expected := range names {
+			if matched := MatchTagPattern(pattern, name); matched != expected {
+				test.Fatalf("Expected match of '%v' against '%v' to be %v but was %v.", name, pattern, expected, matched)
+			}
+		}
+	}
+}
+
+func TestSplitTags(test *testing.T) {
+	words, err := SplitTags(` a  title="Blue Train" 'a b' artist=O'Brien say\ \"hi\" `)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expected := []string{"a", "title=Blue Train", "a b", "artist=O'Brien", `say "hi"`}
+	if len(words) != len(expected) {
+		test.Fatalf("Expected %v words but were %v: %v.", len(expected), len(words), words)
+	}
+	for index, word := range words {
+		if word != expected[index] {
+			test.Fatalf("Expected word '%v' but was '%v'.", expected[index], word)
+		}
+	}
+
+	for _, text := range []string{`title="Blue`, `a\`} {
+		if _, err := SplitTags(text); err == nil {
+			test.Fatalf("Invalid text '%v' was accepted.", text)
+		}
+	}
+}
+
+func TestUnquoteTag(test *testing.T) {
+	for text, expected := range map[string]string{`title="Blue Train"`: "title=Blue Train", "studio=Van Gelder": "studio=Van Gelder",
+		`'a b'=c`: "a b=c", `artist=O'Brien`: "artist=O'Brien", `note=a\<b`: "note=a<b", "": ""} {
+		unquoted, err := UnquoteTag(text)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if unquoted != expected {
+			test.Fatalf("Expected '%v' to be unquoted as '%v' but was '%v'.", text, expected, unquoted)
+		}
+	}
+
+	if _, err := UnquoteTag(`title="Blue`); err == nil {
+		test.Fatal("Unterminated quote was accepted.")
+	}
+}
+
+func TestTagNamesAndPatterns(test *testing.T) {
+	expression, err := Parse("music and (genre-* or not year=2000) and not camera-?")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagNames := TagNames(expression)
+	if len(tagNames) != 2 || tagNames[0] != "music" || tagNames[1] != "year" {
+		test.Fatalf("Unexpected tag names %v.", tagNames)
+	}
+
+	patterns := TagPatterns(expression)
+	if len(patterns) != 2 || patterns[0] != "genre-*" || patterns[1] != "camera-?" {
+		test.Fatalf("Unexpected tag patterns %v.", patterns)
+	}
+}
+
+func TestTerms(test *testing.T) {
+	for text, expected := range map[string]string{
+		"beach":                           "beach",
+		"beach sunset year<2014":          "beach|sunset|year<2014",
+		"beach and (sunset or not night)": "beach|sunset or not night",
+		"beach or sunset":                 "beach or sunset",
+	} {
+		expression, err := Parse(text)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		terms := Terms(expression)
+		formatted := make([]string, len(terms))
+		for index, term := range terms {
+			formatted[index] = term.String()
+		}
+
+		actual := strings.Join(formatted, "|")
+		if actual != expected {
+			test.Fatalf("Expected terms '%v' for '%v' but were '%v'.", expected, text, actual)
+		}
+	}
+}
+
+func TestRenameTag(test *testing.T) {
+	expression, err := Parse("cheese and (tomato or cheese=cheddar) and not cheeses")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	formatted := RenameTag(expression, "cheese", "fromage").String()
+	if formatted != "fromage and (tomato or fromage=cheddar) and not cheeses" {
+		test.Fatalf("Unexpected query '%v'.", formatted)
+	}
+}
+
+func TestEscapeAttributeTags(test *testing.T) {
+	isTag := func(name string) bool { return name == "size" || name == "type" }
+
+	for text, expected := range map[string]string{
+		"size=3":                       "\\size=3",
+		"music and (type=foo or size)": "music and (\\type=foo or size)",
+		"\\size=3 and name=a":          "\\size=3 and name=a",
+		"mtime<2014":                   "mtime<2014",
+	} {
+		actual, err := EscapeAttributeTags(text, isTag)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if actual != expected {
+			test.Fatalf("Expected '%v' for '%v' but was '%v'.", expected, text, actual)
+		}
+	}
+}
+
+func TestParseSize(test *testing.T) {
+	for text, expected := range map[string]int64{"100": 100, "2K": 2048, "100M": 100 << 20, "1g": 1 << 30} {
+		size, err := ParseSize(text)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if size != expected {
+			test.Fatalf("Expected '%v' to be %v bytes but was %v.", text, expected, size)
+		}
+	}
+}
+
+func TestParseTime(test *testing.T) {
+	start, end, err := ParseTime("2014-06")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if start.Year() != 2014 || start.Month() != 6 || start.Day() != 1 || start.Hour() != 0 {
+		test.Fatalf("Unexpected start time %v.", start)
+	}
+	if end.Year() != 2014 || end.Month() != 7 || end.Day() != 1 || end.Hour() != 0 {
+		test.Fatalf("Unexpected end time %v.", end)
+	}
+}
+
+func TestParseDate(test *testing.T) {
+	for text, expected := range map[string][2]string{"2014": {"2014-01-01", "2015-01-01"}, "2014-12": {"2014-12-01", "2015-01-01"}, "2014-06-30": {"2014-06-30", "2014-07-01"}} {
+		start, end, err := ParseDate(text)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if start != expected[0] || end != expected[1] {
+			test.Fatalf("Expected '%v' to be %v to %v but was %v to %v.", text, expected[0], expected[1], start, end)
+		}
+	}
+
+	for _, text := range []string{"June", "2014-6", "2014-06-30T12:00"} {
+		if _, _, err := ParseDate(text); err == nil {
+			test.Fatalf("Invalid date '%v' was accepted.", text)
+		}
+	}
+}
+
+func TestValidateValues(test *testing.T) {
+	valueTypes := map[string]string{"year": "int", "rating": "decimal"}
+
+	for _, text := range []string{"year=2014", "year=1990..2000 rating>3.5", "year~20*", "country=uk", "rating=(1,2.5)"} {
+		expression, err := Parse(text)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if err := ValidateValues(expression, valueTypes); err != nil {
+			test.Fatalf("Valid query '%v' was rejected: %v", text, err)
+		}
+	}
+
+	for _, text := range []string{"year=unknown", "not year<2014.5", "year=1990..now", "rating=(1,high)"} {
+		expression, err := Parse(text)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if err := ValidateValues(expression, valueTypes); err == nil {
+			test.Fatalf("Invalid query '%v' was accepted.", text)
+		}
+	}
+}
+
+func TestSimplify(test *testing.T) {
+	tagExists := func(name string) bool {
+		return name != "unknown"
+	}
+
+	expected := map[string]string{
+		"not not cheese":                         "cheese",
+		"not not not cheese":                     "not cheese",
+		"cheese and tomato and cheese":           "cheese and tomato",
+		"cheese or (tomato or cheese)":           "cheese or tomato",
+		"cheese and not not (tomato and cheese)": "cheese and tomato",
+		"cheese and unknown":                     "false",
+		"cheese or unknown=1":                    "cheese",
+		"cheese and not unknown":                 "cheese",
+		"unknown or not unknown":                 "true",
+		"(a or b) and (b or a) and (a or b)":     "(a or b) and (b or a)",
+	}
+
+	for text, expectedText := range expected {
+		expression, err := Parse(text)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if simplified := Simplify(expression, tagExists).String(); simplified != expectedText {
+			test.Fatalf("Expected '%v' to simplify to '%v' but was '%v'.", text, expectedText, simplified)
+		}
+	}
+
+	expression, err := Parse("cheese and unknown")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if simplified := Simplify(expression, nil).String(); simplified != "cheese and unknown" {
+		test.Fatalf("Unknown tag was folded without a tag lookup: '%v'.", simplified)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Tag names and values that contain spaces, operators or other special
+// characters must be quoted in a query, e.g. 'title="Blue Train"', or have the
+// special characters escaped with a backslash, e.g. 'title=Blue\ Train'. A quote
+// only starts a quoted literal at the beginning of a name or value, so values
+// such as "O'Brien" can be used as is. Within quotes a backslash escapes the
+// quote character and the backslash itself.
+
+// Quotes a tag name or value, if necessary, so that it is read back unchanged
+// when it is used in a query, e.g. 'Blue Train' is quoted as '"Blue Train"'.
+func Quote(text string) string {
+	if !needsQuoting(text) {
+		return text
+	}
+
+	text = strings.Replace(text, `\`, `\\`, -1)
+	text = strings.Replace(text, `"`, `\"`, -1)
+
+	return `"` + text + `"`
+}
+
+// Splits a list of tags into its 'TAG[=VALUE]' words, honouring the quotes and
+// backslash escapes of the query language, e.g. 'a title="Blue Train"' is split
+// into 'a' and 'title=Blue Train'.
+func SplitTags(text string) ([]string, error) {
+	return splitTags(text, true)
+}
+
+// Removes the quotes and backslash escapes of the query language from a single
+// 'TAG[=VALUE]' word, e.g. 'title="Blue Train"' becomes 'title=Blue Train'. The
+// same rules apply as for SplitTags except that whitespace is kept.
+func UnquoteTag(text string) (string, error) {
+	words, err := splitTags(text, false)
+	if err != nil || len(words) == 0 {
+		return "", err
+	}
+
+	return words[0], nil
+}
+
+// unexported
+
+func splitTags(text string, atSpace bool) ([]string, error) {
+	stream := strings.NewReader(text)
+	words := make([]string, 0, 10)
+
+	word := ""
+	inWord := false
+	inValue := false
+	atStart := true
+	for {
+		r, _, err := stream.ReadRune()
+		if err != nil {
+			break
+		}
+
+		switch {
+		case atSpace && unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word)
+				word = ""
+				inWord = false
+				inValue = false
+			}
+
+			atStart = true
+		case r == '\\', atStart && (r == '"' || r == '\''):
+			literal, ok := readLiteral(stream, r)
+			if !ok {
+				if r == '\\' {
+					return nil, fmt.Errorf("a character must follow '\\'.")
+				}
+
+				return nil, fmt.Errorf("unterminated quote: expected a closing %c.", r)
+			}
+
+			word += literal
+			inWord = true
+			atStart = false
+		default:
+			word += string(r)
+			inWord = true
+
+			// the value following the tag name may also be quoted
+			atStart = r == '=' && !inValue
+			if r == '=' {
+				inValue = true
+			}
+		}
+	}
+
+	if inWord {
+		words = append(words, word)
+	}
+
+	return words, nil
+}
+
+// Reads the remainder of a quoted or escaped literal following the opening
+// quote or backslash. Returns false if the literal is not terminated.
+func readLiteral(stream *strings.Reader, opening rune) (string, bool) {
+	if opening == '\\' {
+		r, _, err := stream.ReadRune()
+		if err != nil {
+			return "", false
+		}
+
+		return string(r), true
+	}
+
+	text := ""
+	for {
+		r, _, err := stream.ReadRune()
+		if err != nil {
+			return "", false
+		}
+
+		switch r {
+		case opening:
+			return text, true
+		case '\\':
+			r, _, err = stream.ReadRune()
+			if err != nil {
+				return "", false
+			}
+		}
+
+		text += string(r)
+	}
+}
+
+func needsQuoting(text string) bool {
+	switch strings.ToLower(text) {
+	case "", "and", "or", "not":
+		return true
+	}
+
+	if strings.Contains(text, "..") {
+		return true
+	}
+
+	switch text[0] {
+	case '@', '"', '\'':
+		return true
+	}
+
+	for _, r := range text {
+		switch r {
+		case '(', ')', '=', '<', '>', '~', ',', '\\', '*', '?':
+			return true
+		}
+
+		if unicode.IsSpace(r) || !unicode.IsOneOf(symbolChars, r) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -22,11 +22,14 @@ import (
 	"io"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 var symbolChars = []*unicode.RangeTable{unicode.Letter, unicode.Number, unicode.Punct, unicode.Symbol}
 
 type Token interface {
+	// The byte offset of the token within the query.
+	Offset() int
 }
 
 func Type(token Token) string {
@@ -45,6 +48,12 @@ func Type(token Token) string {
 		return "'or'"
 	case ComparisonOperatorToken:
 		return typedToken.operator
+	case MacroToken:
+		return "macro"
+	case RangeOperatorToken:
+		return "'..'"
+	case CommaToken:
+		return "','"
 	case EndToken:
 		return "EOF"
 	case nil:
@@ -54,39 +63,73 @@ func Type(token Token) string {
 	}
 }
 
+// The position of a token within the query.
+type position struct {
+	offset int
+}
+
+func (position position) Offset() int {
+	return position.offset
+}
+
 type EndToken struct {
+	position
 }
 
 type OpenParenToken struct {
+	position
 }
 
 type CloseParenToken struct {
+	position
 }
 
 type SymbolToken struct {
-	name string
+	position
+	name    string
+	escaped bool
 }
 
 type NotOperatorToken struct {
+	position
 }
 
 type AndOperatorToken struct {
+	position
 }
 
 type OrOperatorToken struct {
+	position
 }
 
 type ComparisonOperatorToken struct {
+	position
 	operator string
 }
 
+type MacroToken struct {
+	position
+	name string
+}
+
+// The '..' that separates the bounds of a range of values, e.g. '2000..2010'.
+type RangeOperatorToken struct {
+	position
+}
+
+// The ',' that separates the values of a set, e.g. '(fr,de,it)'.
+type CommaToken struct {
+	position
+}
+
 type Scanner struct {
+	query     string
 	stream    *strings.Reader
 	lookAhead Token
 }
 
 func NewScanner(query string) *Scanner {
-	return &Scanner{strings.NewReader(query), nil}
+	return &Scanner{query, strings.NewReader(query), nil}
 }
 
 func (scanner *Scanner) LookAhead() (Token, error) {
@@ -119,98 +162,171 @@ func (scanner *Scanner) Next() (Token, error) {
 // unexported
 
 func (scanner *Scanner) readToken() (Token, error) {
-	r, _, err := scanner.stream.ReadRune()
+	r, size, err := scanner.stream.ReadRune()
 	for err == nil && unicode.IsSpace(r) {
-		r, _, err = scanner.stream.ReadRune()
+		r, size, err = scanner.stream.ReadRune()
 	}
 
 	if err == io.EOF {
-		return EndToken{}, nil
+		return EndToken{position{len(scanner.query)}}, nil
 	}
 	if err != nil {
 		return nil, err
 	}
 
+	start := position{scanner.offset() - size}
+
 	switch {
 	case r == rune('('):
-		return OpenParenToken{}, nil
+		return OpenParenToken{start}, nil
 	case r == rune(')'):
-		return CloseParenToken{}, nil
-	case r == rune('='), r == rune('<'), r == rune('>'):
-		return scanner.readComparisonOperatorToken(r)
-	case unicode.IsOneOf(symbolChars, r):
-		return scanner.readTextToken(r)
+		return CloseParenToken{start}, nil
+	case r == rune('='), r == rune('<'), r == rune('>'), r == rune('~'):
+		return scanner.readComparisonOperatorToken(start, r)
+	case r == rune('!') && scanner.peek() == rune('='):
+		return scanner.readComparisonOperatorToken(start, r)
+	case r == rune('.') && scanner.peek() == rune('.'):
+		scanner.stream.ReadRune()
+		return RangeOperatorToken{start}, nil
+	case r == rune(','):
+		return CommaToken{start}, nil
+	case r == rune('@'):
+		return scanner.readMacroToken(start)
+	case r == rune('\\'), r == rune('"'), r == rune('\''), unicode.IsOneOf(symbolChars, r):
+		return scanner.readTextToken(start, r)
 	default:
-		return nil, fmt.Errorf("Unepxected character '%v'.", r)
+		return nil, scanner.errorf(start.offset, "unexpected character '%c'.", r)
 	}
 
 	panic("unreachable")
 }
 
-func (scanner *Scanner) readTextToken(r rune) (Token, error) {
-	text, err := scanner.readString(r)
+// Reads a symbol or logical operator. A symbol that is quoted or escaped, in
+// whole or in part, is never interpretted as an operator or file attribute.
+func (scanner *Scanner) readTextToken(start position, r rune) (Token, error) {
+	text, escaped, err := scanner.readString(r)
 	if err != nil {
 		return nil, err
 	}
 
-	switch text {
-	case "not", "NOT":
-		return NotOperatorToken{}, nil
-	case "and", "AND":
-		return AndOperatorToken{}, nil
-	case "or", "OR":
-		return OrOperatorToken{}, nil
+	if !escaped {
+		switch text {
+		case "not", "NOT":
+			return NotOperatorToken{start}, nil
+		case "and", "AND":
+			return AndOperatorToken{start}, nil
+		case "or", "OR":
+			return OrOperatorToken{start}, nil
+		}
 	}
 
-	return SymbolToken{text}, nil
+	return SymbolToken{start, text, escaped}, nil
 }
 
-func (scanner *Scanner) readComparisonOperatorToken(r rune) (Token, error) {
+func (scanner *Scanner) readMacroToken(start position) (Token, error) {
+	r, _, err := scanner.stream.ReadRune()
+	if err == io.EOF || (err == nil && !unicode.IsOneOf(symbolChars, r)) {
+		return nil, scanner.errorf(start.offset, "query name must follow '@'.")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name, _, err := scanner.readString(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return MacroToken{start, name}, nil
+}
+
+func (scanner *Scanner) readComparisonOperatorToken(start position, r rune) (Token, error) {
 	switch r {
 	case rune('='):
-		return ComparisonOperatorToken{"="}, nil
-	case rune('<'), rune('>'):
-		r2, _, err := scanner.stream.ReadRune()
-		if err != nil {
-			return nil, err
+		return ComparisonOperatorToken{start, "="}, nil
+	case rune('<'), rune('>'), rune('~'), rune('!'):
+		if scanner.peek() == rune('=') {
+			scanner.stream.ReadRune()
+			return ComparisonOperatorToken{start, string(r) + "="}, nil
 		}
 
-		switch r2 {
-		case rune('='):
-			return ComparisonOperatorToken{string(r) + "="}, nil
-		default:
-			scanner.stream.UnreadRune()
-			return ComparisonOperatorToken{string(r)}, nil
-		}
+		return ComparisonOperatorToken{start, string(r)}, nil
 	default:
 		panic("not a valid operator token: " + string(r))
 	}
 }
 
-func (scanner *Scanner) readString(initialRune rune) (string, error) {
-	text := string(initialRune)
+// Reads a symbol, starting with the rune specified, until a delimiter is
+// reached. A symbol that starts with a quote is read literally up to the closing
+// quote and a backslash escapes the character that follows it. Reports whether
+// any part of the symbol was quoted or escaped.
+func (scanner *Scanner) readString(r rune) (string, bool, error) {
+	text := ""
+	escaped := false
 
+	first := true
 	stop := false
 	for !stop {
-		r, _, err := scanner.stream.ReadRune()
-
-		if err == io.EOF {
-			return text, nil
-		}
-		if err != nil {
-			return "", err
-		}
+		offset := scanner.offset() - utf8.RuneLen(r)
 
 		switch {
-		case unicode.IsSpace(r), r == rune(')'), r == rune('('), r == rune('='), r == rune('<'), r == rune('>'):
-			scanner.stream.UnreadRune()
-			return text, nil
+		case r == rune('\\'), first && (r == rune('"') || r == rune('\'')):
+			literal, ok := readLiteral(scanner.stream, r)
+			if !ok {
+				if r == rune('\\') {
+					return "", false, scanner.errorf(offset, "a character must follow '\\'.")
+				}
+
+				return "", false, scanner.errorf(offset, "unterminated quote: expected a closing %c.", r)
+			}
+
+			text += literal
+			escaped = true
 		case unicode.IsOneOf(symbolChars, r):
 			text += string(r)
 		default:
-			return "", fmt.Errorf("Unexpected character '%v'.", r)
+			return "", false, scanner.errorf(offset, "unexpected character '%c'.", r)
+		}
+
+		first = false
+
+		var size int
+		var err error
+		r, size, err = scanner.stream.ReadRune()
+
+		switch {
+		case err == io.EOF:
+			stop = true
+		case err != nil:
+			return "", false, err
+		case unicode.IsSpace(r), r == rune(')'), r == rune('('), r == rune('='), r == rune('<'), r == rune('>'), r == rune('~'), r == rune(','),
+			r == rune('!') && scanner.peek() == rune('='), r == rune('.') && scanner.peek() == rune('.'):
+			scanner.stream.Seek(int64(-size), 1)
+			stop = true
 		}
 	}
 
-	panic("unreachable")
+	return text, escaped, nil
+}
+
+// Retrieves the next rune without consuming it. Returns zero at the end of the
+// query.
+func (scanner *Scanner) peek() rune {
+	r, size, err := scanner.stream.ReadRune()
+	if err != nil {
+		return 0
+	}
+
+	scanner.stream.Seek(int64(-size), 1)
+
+	return r
+}
+
+// The byte offset of the next rune to be read.
+func (scanner *Scanner) offset() int {
+	return len(scanner.query) - scanner.stream.Len()
+}
+
+func (scanner *Scanner) errorf(offset int, format string, args ...interface{}) error {
+	return &ParseError{scanner.query, offset, fmt.Sprintf(format, args...)}
 }
@@ -105,6 +105,120 @@ func TestSingleTagWithValue(test *testing.T) {
 	validateEnd(token, test)
 }
 
+func TestNewComparisonOperators(test *testing.T) {
+	scanner := NewScanner("wow!!=yes artist~The* artist~=beatles")
+
+	expected := []string{"wow!", "!=", "yes", "artist", "~", "The*", "artist", "~=", "beatles"}
+	for index, text := range expected {
+		token, err := scanner.Next()
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if index%3 == 1 {
+			validateComparisonOperator(token, text, test)
+		} else {
+			validateSymbolToken(token, text, test)
+		}
+	}
+
+	token, err := scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateEnd(token, test)
+}
+
+func TestRange(test *testing.T) {
+	scanner := NewScanner("rating=1.5..4")
+
+	token, err := scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateSymbolToken(token, "rating", test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateComparisonOperator(token, "=", test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateSymbolToken(token, "1.5", test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateRangeOperator(token, test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateSymbolToken(token, "4", test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateEnd(token, test)
+}
+
+func TestSet(test *testing.T) {
+	scanner := NewScanner("country=(fr, de,it)")
+
+	token, err := scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateSymbolToken(token, "country", test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateComparisonOperator(token, "=", test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateOpenParen(token, test)
+
+	for index, name := range []string{"fr", "de", "it"} {
+		if index > 0 {
+			token, err = scanner.Next()
+			if err != nil {
+				test.Fatal(err)
+			}
+			validateComma(token, test)
+		}
+
+		token, err = scanner.Next()
+		if err != nil {
+			test.Fatal(err)
+		}
+		validateSymbolToken(token, name, test)
+	}
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateCloseParen(token, test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateEnd(token, test)
+}
+
 func TestComplexQuery(test *testing.T) {
 	scanner := NewScanner("not cheese and (peas or sweetcorn) and not beans and bestbefore=2014")
 
@@ -204,8 +318,114 @@ func TestComplexQuery(test *testing.T) {
 	validateEnd(token, test)
 }
 
+func TestMacro(test *testing.T) {
+	scanner := NewScanner("@holiday-photos and not(@blurry)")
+
+	token, err := scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateMacroToken(token, "holiday-photos", test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateAndOperator(token, test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateNotOperator(token, test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateOpenParen(token, test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateMacroToken(token, "blurry", test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateCloseParen(token, test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateEnd(token, test)
+}
+
+func TestMacroWithoutName(test *testing.T) {
+	scanner := NewScanner("@ beans")
+
+	if _, err := scanner.Next(); err == nil {
+		test.Fatal("Expected error for macro without a name.")
+	}
+}
+
 // unexported
 
+func TestQuotedSymbols(test *testing.T) {
+	scanner := NewScanner(`title="Blue Train" and 'a (b)' or x=a\ b\=c and "not" O'Brien`)
+
+	for _, expected := range []string{"title", "=", "Blue Train", "and", "a (b)", "or", "x", "=", "a b=c", "and", "not", "O'Brien"} {
+		token, err := scanner.Next()
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		switch typedToken := token.(type) {
+		case SymbolToken:
+			validateSymbolToken(token, expected, test)
+		case ComparisonOperatorToken:
+			validateComparisonOperator(token, expected, test)
+		case AndOperatorToken:
+			validateAndOperator(token, test)
+		case OrOperatorToken:
+			validateOrOperator(token, test)
+		default:
+			test.Fatalf("Unexpected token %v.", Type(typedToken))
+		}
+	}
+
+	token, err := scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateEnd(token, test)
+}
+
+func TestUnterminatedQuote(test *testing.T) {
+	for _, text := range []string{`title="Blue Train`, `title='Blue`, `title=Blue\`} {
+		if _, err := NewParser(NewScanner(text)).Parse(); err == nil {
+			test.Fatalf("Invalid query '%v' was accepted.", text)
+		}
+	}
+}
+
+func TestTokenOffsets(test *testing.T) {
+	scanner := NewScanner("  (a and year>=2000) or @b ")
+
+	for _, expected := range []int{2, 3, 5, 9, 13, 15, 19, 21, 24, 27} {
+		token, err := scanner.Next()
+		if err != nil {
+			test.Fatal(err)
+		}
+		if token.Offset() != expected {
+			test.Fatalf("Expected %v token at offset %v but was %v.", Type(token), expected, token.Offset())
+		}
+	}
+}
+
 func validateSymbolToken(token Token, expectedName string, test *testing.T) {
 	tag := token.(SymbolToken)
 	if tag.name != expectedName {
@@ -213,6 +433,16 @@ func validateSymbolToken(token Token, expectedName string, test *testing.T) {
 	}
 }
 
+func validateMacroToken(token Token, expectedName string, test *testing.T) {
+	macro, ok := token.(MacroToken)
+	if !ok {
+		test.Fatalf("Expected macro but was '%v'.", token)
+	}
+	if macro.name != expectedName {
+		test.Fatalf("Expected macro '%v' but was '%v'.", expectedName, macro.name)
+	}
+}
+
 func validateNotOperator(token Token, test *testing.T) {
 	switch token.(type) {
 	case NotOperatorToken:
@@ -244,13 +474,31 @@ func validateComparisonOperator(token Token, operator string, test *testing.T) {
 	switch typedToken := token.(type) {
 	case ComparisonOperatorToken:
 		if typedToken.operator != operator {
-			test.Fatalf("Expected '=' comparison operator but was '%v'", token)
+			test.Fatalf("Expected '%v' comparison operator but was '%v'", operator, token)
 		}
 	default:
 		test.Fatalf("Expected comparison operator but was '%v'.", token)
 	}
 }
 
+func validateRangeOperator(token Token, test *testing.T) {
+	switch token.(type) {
+	case RangeOperatorToken:
+		return
+	default:
+		test.Fatalf("Expected '..' but was '%v'.", token)
+	}
+}
+
+func validateComma(token Token, test *testing.T) {
+	switch token.(type) {
+	case CommaToken:
+		return
+	default:
+		test.Fatalf("Expected ',' but was '%v'.", token)
+	}
+}
+
 func validateOpenParen(token Token, test *testing.T) {
 	switch token.(type) {
 	case OpenParenToken:
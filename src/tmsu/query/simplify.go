@@ -0,0 +1,127 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package query
+
+// Simplifies an expression: double negations are removed, as are repeated
+// operands of 'and' and 'or'. Where tagExists is specified, comparisons with
+// tags that do not exist are replaced with false and the expression is folded
+// accordingly, e.g. 'a or unknown' becomes 'a'.
+func Simplify(expression Expression, tagExists func(name string) bool) Expression {
+	switch exp := expression.(type) {
+	case TagExpression:
+		return foldUnknownTag(exp, exp.Name, tagExists)
+	case ComparisonExpression:
+		return foldUnknownTag(exp, exp.Tag.Name, tagExists)
+	case RangeExpression:
+		return foldUnknownTag(exp, exp.Tag.Name, tagExists)
+	case SetExpression:
+		return foldUnknownTag(exp, exp.Tag.Name, tagExists)
+	case NotExpression:
+		operand := Simplify(exp.Operand, tagExists)
+
+		switch typedOperand := operand.(type) {
+		case NotExpression:
+			return typedOperand.Operand
+		case ConstantExpression:
+			return ConstantExpression{!typedOperand.Value}
+		}
+
+		return NotExpression{operand}
+	case AndExpression, OrExpression:
+		_, isAnd := exp.(AndExpression)
+
+		simplifiedOperands := make([]Expression, 0, 10)
+		for _, operand := range flatten(exp, isAnd, make([]Expression, 0, 10)) {
+			// simplifying may produce a further chain, e.g. from 'not not (a and b)'
+			simplifiedOperands = flatten(Simplify(operand, tagExists), isAnd, simplifiedOperands)
+		}
+
+		operands := make([]Expression, 0, len(simplifiedOperands))
+		for _, operand := range simplifiedOperands {
+			if constant, ok := operand.(ConstantExpression); ok {
+				if constant.Value == isAnd {
+					// 'true' is redundant in 'and' as is 'false' in 'or'
+					continue
+				}
+
+				return constant
+			}
+
+			if !containsExpression(operands, operand) {
+				operands = append(operands, operand)
+			}
+		}
+
+		if len(operands) == 0 {
+			return ConstantExpression{isAnd}
+		}
+
+		result := operands[0]
+		for _, operand := range operands[1:] {
+			if isAnd {
+				result = AndExpression{result, operand}
+			} else {
+				result = OrExpression{result, operand}
+			}
+		}
+
+		return result
+	}
+
+	return expression
+}
+
+// unexported
+
+func foldUnknownTag(expression Expression, tagName string, tagExists func(name string) bool) Expression {
+	if tagExists != nil && !tagExists(tagName) {
+		return ConstantExpression{false}
+	}
+
+	return expression
+}
+
+// Collects the operands of a chain of 'and' or of 'or' operators.
+func flatten(expression Expression, isAnd bool, operands []Expression) []Expression {
+	switch exp := expression.(type) {
+	case AndExpression:
+		if isAnd {
+			operands = flatten(exp.LeftOperand, isAnd, operands)
+			return flatten(exp.RightOperand, isAnd, operands)
+		}
+	case OrExpression:
+		if !isAnd {
+			operands = flatten(exp.LeftOperand, isAnd, operands)
+			return flatten(exp.RightOperand, isAnd, operands)
+		}
+	}
+
+	return append(operands, expression)
+}
+
+func containsExpression(expressions []Expression, searchExpression Expression) bool {
+	searchText := searchExpression.String()
+
+	for _, expression := range expressions {
+		if expression.String() == searchText {
+			return true
+		}
+	}
+
+	return false
+}
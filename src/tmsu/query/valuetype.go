@@ -0,0 +1,134 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// A tag may be declared to have values of a particular type, which is enforced
+// when files are tagged and determines how its values are compared.
+
+// The value types that may be declared for a tag. 'auto' compares values
+// numerically where the query value is a number and as text otherwise.
+var ValueTypes = []string{"auto", "int", "decimal", "date", "text"}
+
+// The layout of a date value once padded to a whole day.
+const DateLayout = "2006-01-02"
+
+// Determines whether the name is that of a value type.
+func IsValueType(name string) bool {
+	for _, valueType := range ValueTypes {
+		if valueType == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validates a value against the specified value type.
+func ValidateValue(valueType, value string) error {
+	var err error
+
+	switch valueType {
+	case "int":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "decimal":
+		_, err = strconv.ParseFloat(value, 64)
+	case "date":
+		_, _, err = ParseDate(value)
+	}
+
+	if err != nil {
+		return fmt.Errorf("'%v' is not a valid %v value.", value, valueType)
+	}
+
+	return nil
+}
+
+// Parses a date, which may be partial, e.g. '2014-06', returning the first day
+// of the period it represents and the first day of the following period, each
+// formatted as per DateLayout.
+func ParseDate(text string) (string, string, error) {
+	for _, timeLayout := range timeLayouts {
+		if len(timeLayout.layout) > len(DateLayout) {
+			continue
+		}
+
+		start, err := time.Parse(timeLayout.layout, text)
+		if err == nil {
+			return start.Format(DateLayout), timeLayout.next(start).Format(DateLayout), nil
+		}
+	}
+
+	return "", "", fmt.Errorf("invalid date '%v': expected e.g. '2014', '2014-06' or '2014-06-30'.", text)
+}
+
+// Validates the values an expression compares against the value types declared
+// for the tags, which are keyed by tag name. Values matched against a pattern
+// are not validated.
+func ValidateValues(expression Expression, valueTypes map[string]string) error {
+	switch exp := expression.(type) {
+	case ComparisonExpression:
+		if exp.Operator == "~" || exp.Operator == "~=" {
+			return nil
+		}
+
+		return validateTagValues(exp.Tag.Name, valueTypes, exp.Value)
+	case RangeExpression:
+		return validateTagValues(exp.Tag.Name, valueTypes, exp.From, exp.To)
+	case SetExpression:
+		return validateTagValues(exp.Tag.Name, valueTypes, exp.Values...)
+	case NotExpression:
+		return ValidateValues(exp.Operand, valueTypes)
+	case AndExpression:
+		if err := ValidateValues(exp.LeftOperand, valueTypes); err != nil {
+			return err
+		}
+
+		return ValidateValues(exp.RightOperand, valueTypes)
+	case OrExpression:
+		if err := ValidateValues(exp.LeftOperand, valueTypes); err != nil {
+			return err
+		}
+
+		return ValidateValues(exp.RightOperand, valueTypes)
+	}
+
+	return nil
+}
+
+// unexported
+
+func validateTagValues(tagName string, valueTypes map[string]string, values ...ValueExpression) error {
+	valueType, ok := valueTypes[tagName]
+	if !ok {
+		return nil
+	}
+
+	for _, value := range values {
+		if err := ValidateValue(valueType, value.Name); err != nil {
+			return fmt.Errorf("tag '%v' has %v values: %v", tagName, valueType, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"fmt"
+	"github.com/mattn/go-sqlite3"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"tmsu/common/log"
+	"tmsu/common/proc"
+)
+
+// How long to wait for another process to release its lock on the database
+// before giving up.
+var BusyTimeout = 5 * time.Second
+
+// The error returned when the database remains locked by another process.
+type LockedError struct {
+	Path      string
+	Processes []*proc.Process
+	Cause     error
+}
+
+func (err LockedError) Error() string {
+	if len(err.Processes) == 0 {
+		return fmt.Sprintf("database '%v' is locked by another process: %v", err.Path, err.Cause)
+	}
+
+	descriptions := make([]string, len(err.Processes))
+	for index, process := range err.Processes {
+		descriptions[index] = fmt.Sprintf("'%v' (pid %v)", strings.TrimSpace(strings.Join(process.CommandLine, " ")), process.Pid)
+	}
+
+	return fmt.Sprintf("database '%v' is locked by %v: %v", err.Path, strings.Join(descriptions, ", "), err.Cause)
+}
+
+// unexported
+
+func isBusy(err error) bool {
+	switch typedErr := err.(type) {
+	case sqlite3.Error:
+		return typedErr.Code == sqlite3.ErrBusy || typedErr.Code == sqlite3.ErrLocked
+	case LockedError:
+		return true
+	}
+
+	return false
+}
+
+// Converts a busy error into a LockedError identifying the processes holding
+// locks on the database. Other errors are returned unchanged.
+func (db *Database) lockedError(err error) error {
+	if _, ok := err.(LockedError); ok || !isBusy(err) {
+		return err
+	}
+
+	path, absErr := filepath.Abs(db.path)
+	if absErr != nil {
+		path = db.path
+	}
+
+	processes, procErr := lockingProcesses(path)
+	if procErr != nil {
+		log.Infof(2, "could not identify the processes locking the database: %v", procErr)
+
+		processes = openingProcesses(path)
+	}
+
+	return LockedError{db.path, processes, err}
+}
+
+// Identifies the processes, other than this one, holding locks on the database
+// or, in WAL mode, its shared memory file. SQLite uses POSIX locks so these are
+// listed in /proc/locks. Processes holding write locks are preferred as a read
+// lock, such as that held by an idle mounted virtual filesystem, only blocks
+// when there is no writer.
+func lockingProcesses(path string) ([]*proc.Process, error) {
+	writerPids := make([]int, 0, 1)
+	readerPids := make([]int, 0, 1)
+
+	for _, suffix := range []string{"", "-shm"} {
+		locks, err := proc.GetFileLocks(path + suffix)
+		if err != nil {
+			if suffix != "" && os.IsNotExist(err) {
+				// not in WAL mode
+				continue
+			}
+			return nil, err
+		}
+
+		for _, lock := range locks {
+			if lock.Pid == os.Getpid() {
+				continue
+			}
+
+			if lock.Write {
+				writerPids = appendPid(writerPids, lock.Pid)
+			} else {
+				readerPids = appendPid(readerPids, lock.Pid)
+			}
+		}
+	}
+
+	pids := writerPids
+	if len(pids) == 0 {
+		pids = readerPids
+	}
+
+	processes := make([]*proc.Process, 0, len(pids))
+	for _, pid := range pids {
+		process, err := proc.GetProcess(pid)
+		if err != nil {
+			continue
+		}
+
+		processes = append(processes, process)
+	}
+
+	return processes, nil
+}
+
+// Identifies the processes that have the database open, for where the lock
+// holders cannot be determined.
+func openingProcesses(path string) []*proc.Process {
+	processes := make([]*proc.Process, 0, 1)
+	for _, suffix := range []string{"", "-journal", "-wal"} {
+		suffixProcesses, err := proc.GetProcessesWithOpenFile(path + suffix)
+		if err != nil {
+			log.Infof(2, "could not identify processes with the database open: %v", err)
+			break
+		}
+
+		for _, process := range suffixProcesses {
+			if !containsProcess(processes, process.Pid) {
+				processes = append(processes, process)
+			}
+		}
+	}
+
+	return processes
+}
+
+// Repeats the operation, rolling back and waiting for increasingly longer
+// between attempts, whilst the database is locked by another process.
+func (db *Database) retryWhileBusy(operation func() error) error {
+	deadline := time.Now().Add(BusyTimeout)
+	delay := 10 * time.Millisecond
+
+	for {
+		err := operation()
+		if err == nil || !isBusy(err) {
+			return err
+		}
+
+		db.Rollback()
+
+		if time.Now().Add(delay).After(deadline) {
+			return db.lockedError(err)
+		}
+
+		log.Infof(2, "database is locked: retrying in %v.", delay)
+
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > time.Second {
+			delay = time.Second
+		}
+	}
+}
+
+func appendPid(pids []int, pid int) []int {
+	for _, existing := range pids {
+		if existing == pid {
+			return pids
+		}
+	}
+
+	return append(pids, pid)
+}
+
+func containsProcess(processes []*proc.Process, pid int) bool {
+	for _, process := range processes {
+		if process.Pid == pid {
+			return true
+		}
+	}
+
+	return false
+}
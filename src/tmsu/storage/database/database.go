@@ -25,12 +25,23 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 	"tmsu/common/log"
 )
 
+// The path of the database to use.
 var Path string
 
+// The name of the directory that holds a local database.
+const LocalDirName = ".tmsu"
+
+// The name of a local database within the local database directory.
+const LocalDbName = "db"
+
 type Database struct {
+	path        string
 	connection  *sql.DB
 	transaction *sql.Tx
 }
@@ -62,21 +73,47 @@ func OpenAt(path string) (*Database, error) {
 		return nil, fmt.Errorf("could not open database: %v", err)
 	}
 
-	transaction, err := connection.Begin()
-	if err != nil {
-		return nil, fmt.Errorf("could not begin transaciton: %v", err)
+	// a single connection ensures the connection settings apply to every statement
+	connection.SetMaxOpenConns(1)
+
+	if _, err := connection.Exec(fmt.Sprintf("PRAGMA busy_timeout = %v", int64(BusyTimeout/time.Millisecond))); err != nil {
+		connection.Close()
+		return nil, fmt.Errorf("could not set busy timeout: %v", err)
 	}
 
-	database := &Database{connection, transaction}
+	database := &Database{path, connection, nil}
+
+	err = database.retryWhileBusy(func() error {
+		if err := database.upgrade(); err != nil {
+			return err
+		}
+
+		// creating the schema takes the write lock so is avoided where possible
+		exists, err := database.SchemaExists()
+		if err != nil {
+			return err
+		}
+		if exists {
+			return database.Rollback()
+		}
 
-	if err := database.CreateSchema(); err != nil {
-		return nil, errors.New("could not create database schema: " + err.Error())
+		return database.CreateSchema()
+	})
+	if err != nil {
+		database.Close()
+		return nil, fmt.Errorf("could not initialise database: %v", err)
+	}
+
+	if err := database.applyJournalMode(); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("could not set journal mode: %v", err)
 	}
 
 	return database, nil
 }
 
 // Executes a SQL query.
+// A transaction is begun implicitly if there is none in progress.
 func (db *Database) Exec(sql string, args ...interface{}) (sql.Result, error) {
 	if log.Verbosity >= 3 {
 		log.Infof(3, "executing update\n"+sql)
@@ -86,10 +123,20 @@ func (db *Database) Exec(sql string, args ...interface{}) (sql.Result, error) {
 		}
 	}
 
-	return db.transaction.Exec(sql, args...)
+	if err := db.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	result, err := db.transaction.Exec(sql, args...)
+	if err != nil {
+		return nil, db.lockedError(err)
+	}
+
+	return result, nil
 }
 
 // Executes a SQL query returning rows.
+// A transaction is begun implicitly if there is none in progress.
 func (db *Database) ExecQuery(sql string, args ...interface{}) (*sql.Rows, error) {
 	if log.Verbosity >= 3 {
 		log.Infof(3, "executing query\n"+sql)
@@ -99,50 +146,254 @@ func (db *Database) ExecQuery(sql string, args ...interface{}) (*sql.Rows, error
 		}
 	}
 
-	return db.transaction.Query(sql, args...)
+	if err := db.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.transaction.Query(sql, args...)
+	if err != nil {
+		return nil, db.lockedError(err)
+	}
+
+	return rows, nil
+}
+
+// Begins a new transaction for making changes.
+//
+// The write lock is acquired immediately so that, should another process be
+// making changes, this waits for it to finish rather than failing part way
+// through when the first change is made.
+func (db *Database) Begin() error {
+	if err := db.begin(); err != nil {
+		return err
+	}
+
+	err := db.retryWhileBusy(func() error {
+		if db.transaction == nil {
+			if err := db.begin(); err != nil {
+				return err
+			}
+		}
+
+		// a no-op update takes the write lock
+		_, err := db.transaction.Exec(`UPDATE version SET schema = schema WHERE 0`)
+		return err
+	})
+	if err != nil {
+		if _, ok := err.(LockedError); ok {
+			return err
+		}
+
+		// the version table does not exist before the schema has been created,
+		// in which case the lock is taken by the first change instead
+		log.Infof(2, "could not acquire write lock: %v", err)
+	}
+
+	return nil
 }
 
 // Commits the current transaction
 func (db *Database) Commit() error {
+	if db.transaction == nil {
+		return errors.New("there is no transaction in progress.")
+	}
+
 	log.Info(2, "committing transaction")
 
-	if err := db.transaction.Commit(); err != nil {
+	transaction := db.transaction
+	db.transaction = nil
+
+	if err := transaction.Commit(); err != nil {
+		// a locked error is returned as is so that the commit can be retried
+		if isBusy(err) {
+			return db.lockedError(err)
+		}
+
 		return fmt.Errorf("could not commit transaction: %v", err)
 	}
 
-	log.Info(2, "beginning new transaction")
+	return nil
+}
 
-	transaction, err := db.connection.Begin()
-	if err != nil {
-		return fmt.Errorf("could not begin new transaction: %v", err)
+// Rolls back the current transaction, if any
+func (db *Database) Rollback() error {
+	if db.transaction == nil {
+		return nil
 	}
 
-	db.transaction = transaction
+	log.Info(2, "rolling back transaction")
+
+	transaction := db.transaction
+	db.transaction = nil
+
+	if err := transaction.Rollback(); err != nil {
+		return fmt.Errorf("could not roll back transaction: %v", err)
+	}
 
 	return nil
 }
 
-// Closes the database connection
+// Closes the database connection, abandoning any uncommitted changes
 func (db *Database) Close() error {
 	log.Info(3, "closing database")
 
+	// the connection cannot be released whilst the transaction is open
+	db.Rollback()
+
 	return db.connection.Close()
 }
 
+// The path of the local database in the specified directory.
+func LocalPath(dir string) string {
+	return filepath.Join(dir, LocalDirName, LocalDbName)
+}
+
+// The root directory of the specified local database path, i.e. the directory
+// containing the local database directory. Returns an empty path if the path is
+// not that of a local database.
+func LocalRoot(path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+
+	dir := filepath.Dir(absPath)
+	if filepath.Base(absPath) != LocalDbName || filepath.Base(dir) != LocalDirName {
+		return ""
+	}
+
+	return filepath.Dir(dir)
+}
+
+// Finds the nearest local database by searching the specified directory and then
+// each of its ancestors in turn. Returns an empty path if there is none.
+func FindLocal(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		path := LocalPath(dir)
+
+		stat, err := os.Stat(path)
+		switch {
+		case err == nil:
+			if !stat.IsDir() {
+				return path, nil
+			}
+		case os.IsNotExist(err), os.IsPermission(err):
+			// keep looking
+		default:
+			return "", err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
 // unexported
 
+func (db *Database) begin() error {
+	if db.transaction != nil {
+		return errors.New("a transaction is already in progress.")
+	}
+
+	log.Info(2, "beginning transaction")
+
+	transaction, err := db.connection.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", db.lockedError(err))
+	}
+
+	db.transaction = transaction
+
+	return nil
+}
+
+// Begins a read transaction if there is no transaction in progress.
+func (db *Database) ensureTransaction() error {
+	if db.transaction != nil {
+		return nil
+	}
+
+	return db.begin()
+}
+
+// Switches the database to the journal mode held in the 'journalMode' setting,
+// if it is not already in use.
+func (db *Database) applyJournalMode() error {
+	setting, err := db.Setting("journalMode")
+	db.Rollback()
+	if err != nil {
+		return err
+	}
+
+	mode := "delete"
+	if setting != nil {
+		mode = strings.ToLower(setting.Value)
+	}
+
+	var currentMode string
+	if err := db.connection.QueryRow(`PRAGMA journal_mode`).Scan(&currentMode); err != nil {
+		return err
+	}
+	if strings.ToLower(currentMode) == mode {
+		return nil
+	}
+
+	log.Infof(2, "changing journal mode from '%v' to '%v'.", currentMode, mode)
+
+	// the journal mode cannot be changed within a transaction
+	if err := db.connection.QueryRow(`PRAGMA journal_mode = ` + mode).Scan(&currentMode); err != nil {
+		return db.lockedError(err)
+	}
+	if strings.ToLower(currentMode) != mode {
+		return fmt.Errorf("journal mode '%v' is not supported for this database.", mode)
+	}
+
+	return nil
+}
+
 func init() {
+	if timeout := os.Getenv("TMSU_BUSY_TIMEOUT"); timeout != "" {
+		log.Info(3, "TMSU_BUSY_TIMEOUT=", timeout)
+
+		milliseconds, err := strconv.ParseUint(timeout, 10, 32)
+		if err != nil {
+			log.Warnf("invalid busy timeout '%v': expected a number of milliseconds.", timeout)
+		} else {
+			BusyTimeout = time.Duration(milliseconds) * time.Millisecond
+		}
+	}
+
 	if path := os.Getenv("TMSU_DB"); path != "" {
 		log.Info(3, "TMSU_DB=", path)
 		Path = path
-	} else {
-		u, err := user.Current()
+		return
+	}
+
+	if workingDir, err := os.Getwd(); err == nil {
+		path, err := FindLocal(workingDir)
 		if err != nil {
-			log.Fatalf("could not identify current user: %v", err)
+			log.Warnf("could not search for local database: %v", err)
+		}
+		if path != "" {
+			Path = path
+			return
 		}
+	}
 
-		Path = filepath.Join(u.HomeDir, ".tmsu", "default.db")
+	u, err := user.Current()
+	if err != nil {
+		log.Fatalf("could not identify current user: %v", err)
 	}
+
+	Path = filepath.Join(u.HomeDir, ".tmsu", "default.db")
 }
 
 func readCount(rows *sql.Rows) (uint, error) {
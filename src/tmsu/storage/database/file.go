@@ -22,12 +22,23 @@ import (
 	"errors"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 	"tmsu/common/fingerprint"
 	"tmsu/entities"
 	"tmsu/query"
 )
 
+// Determines the order in which files are retrieved and which of them are. The
+// zero value retrieves every file in path order.
+type FileSelection struct {
+	Sort    string // 'name', 'path', 'size', 'mtime', 'id' or 'tags', or empty for 'path'
+	Reverse bool   // whether to retrieve the files in descending order
+	Random  uint   // if non-zero, retrieve a random sample of this many files
+	Limit   uint   // if non-zero, the maximum number of files to retrieve
+	Offset  uint   // the number of files to skip
+}
+
 // Retrieves the total number of tracked files.
 func (db *Database) FileCount() (uint, error) {
 	sql := `SELECT count(1)
@@ -44,17 +55,35 @@ func (db *Database) FileCount() (uint, error) {
 
 // The complete set of tracked files.
 func (db *Database) Files() (entities.Files, error) {
-	sql := `SELECT id, directory, name, fingerprint, mod_time, size, is_dir
-	        FROM file
-	        ORDER BY directory || '/' || name`
-
-	rows, err := db.ExecQuery(sql)
+	files := make(entities.Files, 0, 10)
+	err := db.ForEachFile(FileSelection{}, func(file *entities.File) error {
+		files = append(files, file)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+
+	return files, nil
+}
+
+// Calls the function for each of the selected tracked files in turn, without
+// first retrieving the complete set. Stops at, and returns, the first error the
+// function returns.
+func (db *Database) ForEachFile(selection FileSelection, fn func(*entities.File) error) error {
+	builder := NewBuilder()
+	buildSampleStart(builder, selection)
+	builder.AppendSql(`SELECT id, directory, name, fingerprint, mod_time, size, is_dir
+FROM file`)
+	buildSelection(builder, selection, LocalRoot(db.path))
+
+	rows, err := db.ExecQuery(builder.Sql, builder.Params...)
+	if err != nil {
+		return err
+	}
 	defer rows.Close()
 
-	return readFiles(rows, make(entities.Files, 0, 10))
+	return forEachFile(rows, fn)
 }
 
 // Retrieves a specific file.
@@ -106,6 +135,22 @@ func (db *Database) FilesByDirectory(path string) (entities.Files, error) {
 	return readFiles(rows, make(entities.Files, 0, 10))
 }
 
+// Retrieves all files that are stored with a relative path.
+func (db *Database) FilesWithRelativePaths() (entities.Files, error) {
+	sql := `SELECT id, directory, name, fingerprint, mod_time, size, is_dir
+            FROM file
+            WHERE directory NOT LIKE '/%'
+            ORDER BY directory || '/' || name`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFiles(rows, make(entities.Files, 0, 10))
+}
+
 // Retrieves the number of files with the specified fingerprint.
 func (db *Database) FileCountByFingerprint(fingerprint fingerprint.Fingerprint) (uint, error) {
 	sql := `SELECT count(id)
@@ -153,11 +198,12 @@ func (db *Database) UntaggedFiles() (entities.Files, error) {
 	return readFiles(rows, make(entities.Files, 0, 10))
 }
 
-// Retrieves the count of files matching the specified query.
-func (db *Database) QueryFileCount(expression query.Expression) (uint, error) {
-	sql := buildCountQuery(expression)
+// Retrieves the count of files matching the specified query. The value types
+// declared for tags, keyed by tag name, determine how their values are compared.
+func (db *Database) QueryFileCount(expression query.Expression, valueTypes map[string]string) (uint, error) {
+	builder := buildCountQuery(expression, LocalRoot(db.path), valueTypes)
 
-	rows, err := db.ExecQuery(sql)
+	rows, err := db.ExecQuery(builder.Sql, builder.Params...)
 	if err != nil {
 		return 0, err
 	}
@@ -166,16 +212,78 @@ func (db *Database) QueryFileCount(expression query.Expression) (uint, error) {
 	return readCount(rows)
 }
 
-// Retrieves the set of files matching the specified query.
-func (db *Database) QueryFiles(expression query.Expression) (entities.Files, error) {
-	sql := buildQuery(expression)
-	rows, err := db.ExecQuery(sql)
+// Retrieves the set of files matching the specified query. The value types
+// declared for tags, keyed by tag name, determine how their values are compared.
+func (db *Database) QueryFiles(expression query.Expression, valueTypes map[string]string) (entities.Files, error) {
+	files := make(entities.Files, 0, 10)
+	err := db.ForEachQueryFile(expression, valueTypes, FileSelection{}, func(file *entities.File) error {
+		files = append(files, file)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+
+	return files, nil
+}
+
+// Calls the function for each of the selected files matching the specified
+// query in turn, without first retrieving the complete set. Stops at, and
+// returns, the first error the function returns.
+func (db *Database) ForEachQueryFile(expression query.Expression, valueTypes map[string]string, selection FileSelection, fn func(*entities.File) error) error {
+	builder := buildQuery(expression, LocalRoot(db.path), valueTypes, selection)
+
+	rows, err := db.ExecQuery(builder.Sql, builder.Params...)
+	if err != nil {
+		return err
+	}
 	defer rows.Close()
 
-	return readFiles(rows, make(entities.Files, 0, 10))
+	return forEachFile(rows, fn)
+}
+
+// Retrieves the SQL, along with its parameters, that ForEachQueryFile uses to
+// retrieve the selected files matching the specified query.
+func (db *Database) QueryFilesSql(expression query.Expression, valueTypes map[string]string, selection FileSelection) (string, []interface{}) {
+	builder := buildQuery(expression, LocalRoot(db.path), valueTypes, selection)
+
+	return builder.Sql, builder.Params
+}
+
+// Calls the function for each of the files matching at least the minimum
+// number of the specified terms, along with the number of terms it matches, its
+// score. Files are visited in order of decreasing score and then path.
+func (db *Database) ForEachRankedFile(terms []query.Expression, minimumScore uint, valueTypes map[string]string, fn func(*entities.File, uint) error) error {
+	builder := buildRankQuery(terms, minimumScore, LocalRoot(db.path), valueTypes)
+
+	rows, err := db.ExecQuery(builder.Sql, builder.Params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if rows.Err() != nil {
+			return rows.Err()
+		}
+
+		var fileId, score uint
+		var directory, name, fp string
+		var modTime time.Time
+		var size int64
+		var isDir bool
+		err := rows.Scan(&fileId, &directory, &name, &fp, &modTime, &size, &isDir, &score)
+		if err != nil {
+			return err
+		}
+
+		file := &entities.File{fileId, directory, name, fingerprint.Fingerprint(fp), modTime, size, isDir}
+		if err := fn(file, score); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Retrieves the sets of duplicate files within the database.
@@ -358,84 +466,522 @@ func readFile(rows *sql.Rows) (*entities.File, error) {
 }
 
 func readFiles(rows *sql.Rows, files entities.Files) (entities.Files, error) {
+	err := forEachFile(rows, func(file *entities.File) error {
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func forEachFile(rows *sql.Rows, fn func(*entities.File) error) error {
 	for {
 		file, err := readFile(rows)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if file == nil {
 			break
 		}
 
-		files = append(files, file)
+		if err := fn(file); err != nil {
+			return err
+		}
 	}
 
-	return files, nil
+	return nil
 }
 
-func buildCountQuery(expression query.Expression) string {
+func buildCountQuery(expression query.Expression, rootPath string, valueTypes map[string]string) *SqlBuilder {
 	builder := NewBuilder()
 
+	if tagNames, ok := conjunctionTagNames(expression); ok {
+		builder.AppendSql(`SELECT count(1)
+FROM (SELECT file_id
+      FROM file_tag
+      WHERE tag_id IN (SELECT id
+                       FROM tag
+                       WHERE name IN (`)
+		for _, tagName := range tagNames {
+			builder.AppendParam(tagName)
+		}
+		builder.AppendSql(`))
+      GROUP BY file_id
+      HAVING count(DISTINCT tag_id) = ` + strconv.Itoa(len(tagNames)) + ")")
+
+		return builder
+	}
+
 	builder.AppendSql("SELECT count(id) FROM file WHERE 1 == 1 AND\n")
-	buildQueryBranch(expression, builder)
+	buildQueryBranch(expression, builder, rootPath, valueTypes)
 
-	return builder.Sql
+	return builder
 }
 
-func buildQuery(expression query.Expression) string {
+func buildQuery(expression query.Expression, rootPath string, valueTypes map[string]string, selection FileSelection) *SqlBuilder {
 	builder := NewBuilder()
+	buildSampleStart(builder, selection)
+
+	if tagNames, ok := conjunctionTagNames(expression); ok {
+		// a single join rather than a subquery per tag
+		builder.AppendSql(`SELECT file.id, file.directory, file.name, file.fingerprint, file.mod_time, file.size, file.is_dir
+FROM file
+INNER JOIN file_tag ON file_tag.file_id = file.id
+WHERE file_tag.tag_id IN (SELECT id
+                          FROM tag
+                          WHERE name IN (`)
+		for _, tagName := range tagNames {
+			builder.AppendParam(tagName)
+		}
+		builder.AppendSql(`))
+GROUP BY file.id
+HAVING count(DISTINCT file_tag.tag_id) = ` + strconv.Itoa(len(tagNames)))
+		buildSelection(builder, selection, rootPath)
+
+		return builder
+	}
 
 	builder.AppendSql("SELECT id, directory, name, fingerprint, mod_time, size, is_dir FROM file WHERE 1==1 AND\n")
-	buildQueryBranch(expression, builder)
-	builder.AppendSql("ORDER BY directory || '/' || name")
+	buildQueryBranch(expression, builder, rootPath, valueTypes)
+	buildSelection(builder, selection, rootPath)
+
+	return builder
+}
 
-	return builder.Sql
+// Builds a query scoring each file by the number of terms it matches.
+func buildRankQuery(terms []query.Expression, minimumScore uint, rootPath string, valueTypes map[string]string) *SqlBuilder {
+	builder := NewBuilder()
+
+	builder.AppendSql(`SELECT id, directory, name, fingerprint, mod_time, size, is_dir, score
+FROM (SELECT id, directory, name, fingerprint, mod_time, size, is_dir,`)
+	for index, term := range terms {
+		if index > 0 {
+			builder.AppendSql("+")
+		}
+
+		builder.AppendSql("(CASE WHEN (\n")
+		buildQueryBranch(term, builder, rootPath, valueTypes)
+		builder.AppendSql(") THEN 1 ELSE 0 END)\n")
+	}
+	builder.AppendSql(`AS score
+      FROM file)
+WHERE score >= ` + strconv.FormatUint(uint64(minimumScore), 10) + `
+ORDER BY score DESC,`)
+	buildPath(builder, rootPath)
+
+	return builder
 }
 
-func buildQueryBranch(expression query.Expression, builder *SqlBuilder) {
+// Opens the outer query that sorts a random sample of files. The sample itself is
+// taken by buildSelection, which closes the subquery.
+func buildSampleStart(builder *SqlBuilder, selection FileSelection) {
+	if selection.Random > 0 && selection.Sort != "" {
+		builder.AppendSql(`SELECT id, directory, name, fingerprint, mod_time, size, is_dir
+FROM (`)
+	}
+}
+
+// Appends the ORDER BY and LIMIT clauses that select the files.
+func buildSelection(builder *SqlBuilder, selection FileSelection, rootPath string) {
+	if selection.Random > 0 {
+		builder.AppendSql("\nORDER BY random()\nLIMIT " + strconv.FormatUint(uint64(selection.Random), 10))
+
+		if selection.Sort == "" {
+			return
+		}
+
+		// sort the sample
+		builder.AppendSql(")")
+	}
+
+	direction := ""
+	if selection.Reverse {
+		direction = " DESC"
+	}
+
+	builder.AppendSql("\nORDER BY")
+	switch selection.Sort {
+	case "", "path":
+	case "name":
+		builder.AppendSql("name" + direction + ",")
+	case "size":
+		builder.AppendSql("size" + direction + ",")
+	case "mtime":
+		builder.AppendSql("mod_time" + direction + ",")
+	case "id":
+		builder.AppendSql("id" + direction + ",")
+	case "tags":
+		builder.AppendSql(`(SELECT count(DISTINCT tag_id)
+ FROM file_tag
+ WHERE file_tag.file_id = id)` + direction + ",")
+	default:
+		panic("unsupported sort: " + selection.Sort)
+	}
+	buildPath(builder, rootPath)
+	builder.AppendSql(direction)
+
+	if selection.Limit > 0 || selection.Offset > 0 {
+		// a negative limit is no limit
+		limit := "-1"
+		if selection.Limit > 0 {
+			limit = strconv.FormatUint(uint64(selection.Limit), 10)
+		}
+
+		builder.AppendSql("\nLIMIT " + limit + " OFFSET " + strconv.FormatUint(uint64(selection.Offset), 10))
+	}
+}
+
+// Appends the SQL expression for a file's path. Files are ordered by this path
+// so that, when paths are stored relative to the root of a local database, the
+// order is that of the absolute paths.
+func buildPath(builder *SqlBuilder, rootPath string) {
+	buildDirectory(builder, rootPath)
+	builder.AppendSql("|| '/' || name")
+}
+
+// Appends the SQL expression for a file's absolute directory, resolving those
+// stored relative to the root of a local database.
+func buildDirectory(builder *SqlBuilder, rootPath string) {
+	if rootPath == "" {
+		builder.AppendSql("directory")
+		return
+	}
+
+	builder.AppendSql("CASE WHEN directory = '.' THEN ")
+	builder.AppendParam(rootPath)
+	builder.AppendSql(" WHEN substr(directory, 1, 1) = '/' THEN directory ELSE ")
+	builder.AppendParam(rootPath + "/")
+	builder.AppendSql(" || directory END")
+}
+
+// Determines whether the expression only requires files to have each of a set
+// of tags, e.g. 'a and b and c', returning the distinct tag names if so.
+func conjunctionTagNames(expression query.Expression) ([]string, bool) {
 	switch exp := expression.(type) {
 	case query.TagExpression:
-		builder.AppendSql(`id IN (SELECT file_id
-FROM file_tag
-WHERE tag_id = (SELECT id
-                FROM tag
-                WHERE name = '` + exp.Name + `'))
-`)
-	case query.ComparisonExpression:
-		var value, valueExpression string
-		_, err := strconv.ParseFloat(exp.Value.Name, 64)
-		if err == nil {
-			value = exp.Value.Name
-			valueExpression = "CAST(name AS float)"
-		} else {
-			value = "'" + exp.Value.Name + "'"
-			valueExpression = "name"
+		return []string{exp.Name}, true
+	case query.AndExpression:
+		leftTagNames, ok := conjunctionTagNames(exp.LeftOperand)
+		if !ok {
+			return nil, false
+		}
+
+		rightTagNames, ok := conjunctionTagNames(exp.RightOperand)
+		if !ok {
+			return nil, false
+		}
+
+		tagNames := leftTagNames
+		for _, tagName := range rightTagNames {
+			if !containsString(tagNames, tagName) {
+				tagNames = append(tagNames, tagName)
+			}
 		}
 
+		return tagNames, true
+	}
+
+	return nil, false
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func buildQueryBranch(expression query.Expression, builder *SqlBuilder, rootPath string, valueTypes map[string]string) {
+	switch exp := expression.(type) {
+	case query.TagExpression:
 		builder.AppendSql(`id IN (SELECT file_id
 FROM file_tag
 WHERE tag_id = (SELECT id
                 FROM tag
-                WHERE name = '` + exp.Tag.Name + `')
-AND value_id IN (SELECT id
-                 FROM value
-                 WHERE ` + valueExpression + ` ` + exp.Operator + ` ` + value + `))`)
+                WHERE name = `)
+		builder.AppendParam(exp.Name)
+		builder.AppendSql("))\n")
+	case query.TagPatternExpression:
+		builder.AppendSql(`id IN (SELECT file_id
+FROM file_tag
+WHERE tag_id IN (SELECT id
+                 FROM tag
+                 WHERE name GLOB `)
+		builder.AppendParam(tagPatternGlob(exp.Pattern))
+		builder.AppendSql("))\n")
+	case query.ComparisonExpression:
+		buildValueBranch(exp.Tag.Name, builder)
+		buildValueComparison(exp, valueTypes[exp.Tag.Name], builder)
+		builder.AppendSql("))\n")
+	case query.RangeExpression:
+		buildValueBranch(exp.Tag.Name, builder)
+		buildValueRange(exp, valueTypes[exp.Tag.Name], builder)
+		builder.AppendSql("))\n")
+	case query.SetExpression:
+		buildValueBranch(exp.Tag.Name, builder)
+		buildValueSet(exp, valueTypes[exp.Tag.Name], builder)
+		builder.AppendSql("))\n")
+	case query.AttributeExpression:
+		buildAttributeBranch(exp, builder, rootPath)
 	case query.NotExpression:
 		builder.AppendSql("\nNOT\n")
-		buildQueryBranch(exp.Operand, builder)
+		buildQueryBranch(exp.Operand, builder, rootPath, valueTypes)
 	case query.AndExpression:
-		buildQueryBranch(exp.LeftOperand, builder)
+		buildQueryBranch(exp.LeftOperand, builder, rootPath, valueTypes)
 		builder.AppendSql("\nAND\n")
-		buildQueryBranch(exp.RightOperand, builder)
+		buildQueryBranch(exp.RightOperand, builder, rootPath, valueTypes)
 	case query.OrExpression:
 		builder.AppendSql("(\n")
-		buildQueryBranch(exp.LeftOperand, builder)
+		buildQueryBranch(exp.LeftOperand, builder, rootPath, valueTypes)
 		builder.AppendSql("\nOR\n")
-		buildQueryBranch(exp.RightOperand, builder)
+		buildQueryBranch(exp.RightOperand, builder, rootPath, valueTypes)
 		builder.AppendSql(")\n")
 	case query.EmptyExpression:
 		builder.AppendSql("1 == 1\n")
+	case query.ConstantExpression:
+		if exp.Value {
+			builder.AppendSql("1 == 1\n")
+		} else {
+			builder.AppendSql("1 == 0\n")
+		}
 	default:
 		panic("Unsupported expression type.")
 	}
 }
+
+func buildAttributeBranch(exp query.AttributeExpression, builder *SqlBuilder, rootPath string) {
+	switch exp.Name {
+	case "size":
+		size, _ := query.ParseSize(exp.Value.Name)
+
+		builder.AppendSql("size ")
+		buildComparison(exp.Operator, size, builder)
+		builder.AppendSql("\n")
+	case "mtime":
+		// a time identifies a period, e.g. '2014-06' is the whole of June
+		start, end, _ := query.ParseTime(exp.Value.Name)
+
+		buildPeriodComparison("datetime(mod_time)", exp.Operator, sqlTime(start), sqlTime(end), builder)
+		builder.AppendSql("\n")
+	case "name", "dir":
+		if exp.Name == "dir" {
+			// compare the absolute directory, not the stored relative one
+			buildDirectory(builder, rootPath)
+		} else {
+			builder.AppendSql("name")
+		}
+
+		builder.AppendSql(" ")
+		buildComparison(exp.Operator, exp.Value.Name, builder)
+		builder.AppendSql("\n")
+	case "type":
+		if (exp.Value.Name == "dir") == (exp.Operator == "=") {
+			builder.AppendSql("is_dir = 1\n")
+		} else {
+			builder.AppendSql("is_dir = 0\n")
+		}
+	default:
+		panic("unsupported file attribute: " + exp.Name)
+	}
+}
+
+// Appends the start of a condition on the values a file is tagged with for the
+// specified tag. The caller appends the condition on the value and closes it.
+func buildValueBranch(tagName string, builder *SqlBuilder) {
+	builder.AppendSql(`id IN (SELECT file_id
+FROM file_tag
+WHERE tag_id = (SELECT id
+                FROM tag
+                WHERE name = `)
+	builder.AppendParam(tagName)
+	builder.AppendSql(`)
+AND value_id IN (SELECT id
+                 FROM value
+                 WHERE `)
+}
+
+// The SQL expression for the start of a date value: partial dates such as
+// '2014-06' are padded to their first day.
+const dateValueExpression = `CASE length(name) WHEN 4 THEN name || '-01-01' WHEN 7 THEN name || '-01' ELSE name END`
+
+// Appends the comparison of a tag's values with the value of the expression.
+func buildValueComparison(exp query.ComparisonExpression, valueType string, builder *SqlBuilder) {
+	if exp.Operator == "~" || exp.Operator == "~=" {
+		// patterns are always matched against the text
+		builder.AppendSql("name ")
+		buildComparison(exp.Operator, exp.Value.Name, builder)
+		return
+	}
+
+	if valueType == "date" {
+		start, end, _ := query.ParseDate(exp.Value.Name)
+		buildPeriodComparison(dateValueExpression, exp.Operator, start, end, builder)
+		return
+	}
+
+	values, valueExpression := comparisonValues(valueType, exp.Value)
+
+	builder.AppendSql(valueExpression + " ")
+	buildComparison(exp.Operator, values[0], builder)
+}
+
+// Appends the comparison of a tag's values with the inclusive range of the
+// expression.
+func buildValueRange(exp query.RangeExpression, valueType string, builder *SqlBuilder) {
+	if exp.Operator == "!=" {
+		builder.AppendSql("NOT ")
+	}
+
+	if valueType == "date" {
+		start, _, _ := query.ParseDate(exp.From.Name)
+		_, end, _ := query.ParseDate(exp.To.Name)
+		buildPeriodComparison(dateValueExpression, "=", start, end, builder)
+		return
+	}
+
+	values, valueExpression := comparisonValues(valueType, exp.From, exp.To)
+
+	builder.AppendSql(valueExpression + " BETWEEN ")
+	builder.AppendParam(values[0])
+	builder.AppendSql(" AND ")
+	builder.AppendParam(values[1])
+}
+
+// Appends the comparison of a tag's values with the set of values of the
+// expression.
+func buildValueSet(exp query.SetExpression, valueType string, builder *SqlBuilder) {
+	if exp.Operator == "!=" {
+		builder.AppendSql("NOT ")
+	}
+
+	if valueType == "date" {
+		builder.AppendSql("(")
+		for index, value := range exp.Values {
+			if index > 0 {
+				builder.AppendSql(" OR ")
+			}
+
+			start, end, _ := query.ParseDate(value.Name)
+			buildPeriodComparison(dateValueExpression, "=", start, end, builder)
+		}
+		builder.AppendSql(")")
+		return
+	}
+
+	values, valueExpression := comparisonValues(valueType, exp.Values...)
+
+	builder.AppendSql(valueExpression + " IN (")
+	for index, value := range values {
+		if index > 0 {
+			builder.AppendSql(", ")
+		}
+		builder.AppendParam(value)
+	}
+	builder.AppendSql(")")
+}
+
+// Appends the comparison of an SQL expression with a period, which is
+// identified by its start and the start of the following period. A value is
+// equal to a period if it falls within it.
+func buildPeriodComparison(sqlExpression, operator string, start, end interface{}, builder *SqlBuilder) {
+	switch operator {
+	case "=", "!=":
+		if operator == "!=" {
+			builder.AppendSql("NOT ")
+		}
+
+		builder.AppendSql("(" + sqlExpression + " >= ")
+		builder.AppendParam(start)
+		builder.AppendSql(" AND " + sqlExpression + " < ")
+		builder.AppendParam(end)
+		builder.AppendSql(")")
+	case "<":
+		builder.AppendSql(sqlExpression + " < ")
+		builder.AppendParam(start)
+	case "<=":
+		builder.AppendSql(sqlExpression + " < ")
+		builder.AppendParam(end)
+	case ">":
+		builder.AppendSql(sqlExpression + " >= ")
+		builder.AppendParam(end)
+	case ">=":
+		builder.AppendSql(sqlExpression + " >= ")
+		builder.AppendParam(start)
+	default:
+		panic("unsupported operator for a period: " + operator)
+	}
+}
+
+// Determines the parameters for comparing against the specified values along
+// with the SQL expression they are to be compared to, according to the value
+// type. Without a declared type, the values are compared numerically if they
+// are all numbers.
+func comparisonValues(valueType string, valueExpressions ...query.ValueExpression) ([]interface{}, string) {
+	values := make([]interface{}, len(valueExpressions))
+
+	switch valueType {
+	case "int":
+		for index, valueExpression := range valueExpressions {
+			values[index], _ = strconv.ParseInt(valueExpression.Name, 10, 64)
+		}
+
+		return values, "CAST(name AS integer)"
+	case "decimal":
+		for index, valueExpression := range valueExpressions {
+			values[index], _ = strconv.ParseFloat(valueExpression.Name, 64)
+		}
+
+		return values, "CAST(name AS real)"
+	case "text":
+		for index, valueExpression := range valueExpressions {
+			values[index] = valueExpression.Name
+		}
+
+		return values, "name"
+	}
+
+	for index, valueExpression := range valueExpressions {
+		number, err := strconv.ParseFloat(valueExpression.Name, 64)
+		if err != nil {
+			return comparisonValues("text", valueExpressions...)
+		}
+
+		values[index] = number
+	}
+
+	return values, "CAST(name AS float)"
+}
+
+// Converts a tag name pattern to the equivalent GLOB pattern. Only '*' and '?'
+// are wildcards in a tag name pattern (see query.MatchTagPattern) so the '['
+// that would start a GLOB character class is matched literally.
+func tagPatternGlob(pattern string) string {
+	return strings.Replace(pattern, "[", "[[]", -1)
+}
+
+// Appends the comparison of the preceding SQL expression with the value.
+func buildComparison(operator string, value interface{}, builder *SqlBuilder) {
+	switch operator {
+	case "~":
+		builder.AppendSql("GLOB ")
+		builder.AppendParam(value)
+	case "~=":
+		builder.AppendSql("= ")
+		builder.AppendParam(value)
+		builder.AppendSql(" COLLATE NOCASE")
+	default:
+		builder.AppendSql(operator + " ")
+		builder.AppendParam(value)
+	}
+}
+
+// Formats a time for comparison with the result of SQLite's datetime function.
+func sqlTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
@@ -25,7 +25,7 @@ import (
 
 // The complete set of queries.
 func (db *Database) Queries() (entities.Queries, error) {
-	sql := `SELECT text
+	sql := `SELECT text, name
 	        FROM query
 	        ORDER BY text`
 
@@ -40,7 +40,7 @@ func (db *Database) Queries() (entities.Queries, error) {
 
 // Retrieves the specified query.
 func (db *Database) Query(text string) (*entities.Query, error) {
-	sql := `SELECT 1
+	sql := `SELECT text, name
             FROM query
             WHERE text = ?`
 
@@ -53,12 +53,27 @@ func (db *Database) Query(text string) (*entities.Query, error) {
 	return readQuery(rows)
 }
 
-// Adds a query to the database.
-func (db *Database) InsertQuery(text string) (*entities.Query, error) {
-	sql := `INSERT INTO query (text)
-	        VALUES (?)`
+// Retrieves the query with the specified name.
+func (db *Database) QueryByName(name string) (*entities.Query, error) {
+	sql := `SELECT text, name
+            FROM query
+            WHERE name = ?`
 
-	result, err := db.Exec(sql, text)
+	rows, err := db.ExecQuery(sql, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readQuery(rows)
+}
+
+// Adds a query to the database. An empty name leaves the query unnamed.
+func (db *Database) InsertQuery(text, name string) (*entities.Query, error) {
+	sql := `INSERT INTO query (text, name)
+	        VALUES (?, ?)`
+
+	result, err := db.Exec(sql, text, nullString(name))
 	if err != nil {
 		return nil, err
 	}
@@ -71,7 +86,32 @@ func (db *Database) InsertQuery(text string) (*entities.Query, error) {
 		return nil, errors.New("expected exactly one row to be affected.")
 	}
 
-	return &entities.Query{text}, nil
+	return &entities.Query{text, name}, nil
+}
+
+// Changes the text of the specified query.
+func (db *Database) RenameQuery(text, newText string) (*entities.Query, error) {
+	sql := `UPDATE query
+	        SET text = ?
+	        WHERE text = ?`
+
+	result, err := db.Exec(sql, newText, text)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, errors.New("no such query '" + text + "'.")
+	}
+	if rowsAffected != 1 {
+		return nil, errors.New("expected exactly one row to be affected.")
+	}
+
+	return db.Query(newText)
 }
 
 // Removes a query from the database.
@@ -109,12 +149,13 @@ func readQuery(rows *sql.Rows) (*entities.Query, error) {
 	}
 
 	var text string
-	err := rows.Scan(&text)
+	var name sql.NullString
+	err := rows.Scan(&text, &name)
 	if err != nil {
 		return nil, err
 	}
 
-	return &entities.Query{text}, nil
+	return &entities.Query{text, name.String}, nil
 }
 
 func readQueries(rows *sql.Rows, queries entities.Queries) (entities.Queries, error) {
@@ -132,3 +173,11 @@ func readQueries(rows *sql.Rows, queries entities.Queries) (entities.Queries, er
 
 	return queries, nil
 }
+
+func nullString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+
+	return value
+}
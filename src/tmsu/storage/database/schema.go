@@ -21,6 +21,24 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// The tables created by CreateSchema.
+var schemaTables = []string{"tag", "file", "value", "file_tag", "implication", "query", "setting", "tag_setting", "version"}
+
+// Determines whether all of the schema's tables exist.
+func (db *Database) SchemaExists() (bool, error) {
+	for _, table := range schemaTables {
+		exists, err := db.tableExists(table)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
 func (db *Database) CreateSchema() error {
 	if err := db.CreateTagTable(); err != nil {
 		return err
@@ -38,6 +56,10 @@ func (db *Database) CreateSchema() error {
 		return err
 	}
 
+	if err := db.CreateImplicationTable(); err != nil {
+		return err
+	}
+
 	if err := db.CreateQueryTable(); err != nil {
 		return err
 	}
@@ -46,6 +68,14 @@ func (db *Database) CreateSchema() error {
 		return err
 	}
 
+	if err := db.CreateTagSettingTable(); err != nil {
+		return err
+	}
+
+	if err := db.CreateVersionTable(); err != nil {
+		return err
+	}
+
 	if err := db.Commit(); err != nil {
 		return err
 	}
@@ -135,8 +165,9 @@ func (db *Database) CreateFileTagTable() error {
 		return err
 	}
 
-	sql = `CREATE INDEX IF NOT EXISTS idx_file_tag_tag_id
-           ON file_tag(tag_id)`
+	// covers the retrieval of the files, and their values, for a tag
+	sql = `CREATE INDEX IF NOT EXISTS idx_file_tag_tag_id_file_id
+           ON file_tag(tag_id, file_id, value_id)`
 
 	if _, err := db.Exec(sql); err != nil {
 		return err
@@ -152,15 +183,39 @@ func (db *Database) CreateFileTagTable() error {
 	return nil
 }
 
+func (db *Database) CreateImplicationTable() error {
+	sql := `CREATE TABLE IF NOT EXISTS implication (
+                tag_id INTEGER NOT NULL,
+                implied_tag_id INTEGER NOT NULL,
+                PRIMARY KEY (tag_id, implied_tag_id),
+                FOREIGN KEY (tag_id) REFERENCES tag(id),
+                FOREIGN KEY (implied_tag_id) REFERENCES tag(id)
+            )`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (db *Database) CreateQueryTable() error {
 	sql := `CREATE TABLE IF NOT EXISTS query (
-                text TEXT PRIMARY KEY
+                text TEXT PRIMARY KEY,
+                name TEXT
             )`
 
 	if _, err := db.Exec(sql); err != nil {
 		return err
 	}
 
+	sql = `CREATE UNIQUE INDEX IF NOT EXISTS idx_query_name
+           ON query(name)`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -176,3 +231,40 @@ func (db *Database) CreateSettingTable() error {
 
 	return nil
 }
+
+func (db *Database) CreateTagSettingTable() error {
+	sql := `CREATE TABLE IF NOT EXISTS tag_setting (
+                tag_id INTEGER NOT NULL,
+                name TEXT NOT NULL,
+                value TEXT NOT NULL,
+                PRIMARY KEY (tag_id, name),
+                FOREIGN KEY (tag_id) REFERENCES tag(id)
+            )`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *Database) CreateVersionTable() error {
+	sql := `CREATE TABLE IF NOT EXISTS version (
+                schema INTEGER NOT NULL
+            )`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	// a new database is created at the latest schema version
+	sql = `INSERT INTO version (schema)
+           SELECT ?
+           WHERE NOT EXISTS (SELECT 1 FROM version)`
+
+	if _, err := db.Exec(sql, LatestSchemaVersion()); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -19,6 +19,7 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"tmsu/entities"
 )
 
@@ -51,6 +52,27 @@ func (db *Database) Setting(name string) (*entities.Setting, error) {
 	return readSetting(rows)
 }
 
+// Updates the specified setting, adding it if it does not yet exist.
+func (db *Database) UpdateSetting(name, value string) (*entities.Setting, error) {
+	sql := `INSERT OR REPLACE INTO setting (name, value)
+            VALUES (?, ?)`
+
+	result, err := db.Exec(sql, name, value)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected != 1 {
+		return nil, errors.New("expected exactly one row to be affected.")
+	}
+
+	return &entities.Setting{name, value}, nil
+}
+
 //
 
 func readSetting(rows *sql.Rows) (*entities.Setting, error) {
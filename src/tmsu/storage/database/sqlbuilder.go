@@ -21,6 +21,7 @@ import (
 	"strconv"
 )
 
+// Builds up a SQL statement along with the parameters to bind to it.
 type SqlBuilder struct {
 	Sql    string
 	Params []interface{}
@@ -33,12 +34,15 @@ func NewBuilder() *SqlBuilder {
 	return &SqlBuilder{"", make([]interface{}, 0), 1, false}
 }
 
+// Appends literal SQL text.
 func (builder *SqlBuilder) AppendSql(sql string) {
 	builder.Sql += " " + sql
 
 	builder.needsComma = false
 }
 
+// Appends a numbered parameter placeholder and records the value to bind.
+// Consecutive parameters are separated by commas.
 func (builder *SqlBuilder) AppendParam(value interface{}) {
 	if builder.needsComma {
 		builder.Sql += ","
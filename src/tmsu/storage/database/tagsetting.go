@@ -0,0 +1,133 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"errors"
+	"tmsu/entities"
+)
+
+// Retrieves the settings of the specified tag.
+func (db *Database) TagSettings(tagId uint) (entities.Settings, error) {
+	sql := `SELECT name, value
+            FROM tag_setting
+            WHERE tag_id = ?
+            ORDER BY name`
+
+	rows, err := db.ExecQuery(sql, tagId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readSettings(rows, make(entities.Settings, 0, 10))
+}
+
+// Retrieves the specified setting of a tag.
+func (db *Database) TagSetting(tagId uint, name string) (*entities.Setting, error) {
+	sql := `SELECT name, value
+            FROM tag_setting
+            WHERE tag_id = ? AND name = ?`
+
+	rows, err := db.ExecQuery(sql, tagId, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readSetting(rows)
+}
+
+// Retrieves the values of the specified setting for all tags that have it,
+// keyed by tag name.
+func (db *Database) TagSettingsByName(name string) (map[string]string, error) {
+	sql := `SELECT t.name, ts.value
+            FROM tag_setting ts, tag t
+            WHERE ts.tag_id = t.id AND ts.name = ?`
+
+	rows, err := db.ExecQuery(sql, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := make(map[string]string)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var tagName, value string
+		if err := rows.Scan(&tagName, &value); err != nil {
+			return nil, err
+		}
+
+		settings[tagName] = value
+	}
+
+	return settings, nil
+}
+
+// Updates the specified setting of a tag, adding it if it does not yet exist.
+func (db *Database) UpdateTagSetting(tagId uint, name, value string) (*entities.Setting, error) {
+	sql := `INSERT OR REPLACE INTO tag_setting (tag_id, name, value)
+            VALUES (?, ?, ?)`
+
+	result, err := db.Exec(sql, tagId, name, value)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected != 1 {
+		return nil, errors.New("expected exactly one row to be affected.")
+	}
+
+	return &entities.Setting{name, value}, nil
+}
+
+// Copies the settings of one tag to another.
+func (db *Database) CopyTagSettings(sourceTagId, destTagId uint) error {
+	sql := `INSERT OR REPLACE INTO tag_setting (tag_id, name, value)
+            SELECT ?, name, value
+            FROM tag_setting
+            WHERE tag_id = ?`
+
+	_, err := db.Exec(sql, destTagId, sourceTagId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Deletes the settings of the specified tag.
+func (db *Database) DeleteTagSettings(tagId uint) error {
+	sql := `DELETE FROM tag_setting
+            WHERE tag_id = ?`
+
+	_, err := db.Exec(sql, tagId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
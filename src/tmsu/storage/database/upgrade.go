@@ -0,0 +1,493 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"tmsu/common/log"
+	"tmsu/query"
+)
+
+type migration struct {
+	description string
+	apply       func(db *Database) error
+}
+
+// The ordered set of schema migrations. A database at schema version N has had
+// the first N migrations applied to it. To change the schema, append a new
+// migration to the end of this list: never alter or reorder existing entries.
+var migrations = []migration{
+	{"add file modification time (v0.0.6)", addFileModTime},
+	{"add file size and directory flag, merge file-tag tables (v0.1.0)", addFileSizeAndMergeFileTags},
+	{"add tag values (v0.4.0)", addFileTagValues},
+	{"add tag implications", addImplications},
+	{"add query names", addQueryNames},
+	{"add tag settings", addTagSettings},
+	{"add covering file-tag index", addCoveringFileTagIndex},
+	{"escape tags named like file attributes in saved queries", escapeAttributeTagsInQueries},
+}
+
+// The schema version of a database created by this version of the program.
+func LatestSchemaVersion() uint {
+	return uint(len(migrations))
+}
+
+// Retrieves the schema version of the database.
+func (db *Database) SchemaVersion() (uint, error) {
+	exists, err := db.tableExists("version")
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		// databases from before schema versioning was introduced
+		return 0, nil
+	}
+
+	sql := `SELECT schema
+            FROM version`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, nil
+	}
+	if rows.Err() != nil {
+		return 0, rows.Err()
+	}
+
+	var version uint
+	if err := rows.Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// unexported
+
+func (db *Database) upgrade() error {
+	exists, err := db.tableExists("tag")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		// new database: the schema is created at the latest version
+		return nil
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return upgradeError(err, "could not determine schema version")
+	}
+
+	latestVersion := LatestSchemaVersion()
+
+	if version > latestVersion {
+		return fmt.Errorf("database schema version %v is newer than the latest version supported by this version of TMSU (%v): please upgrade TMSU.", version, latestVersion)
+	}
+	if version == latestVersion {
+		return nil
+	}
+
+	log.Warnf("upgrading database from schema version %v to %v.", version, latestVersion)
+
+	backupPath, err := db.backup(version)
+	if err != nil {
+		return fmt.Errorf("could not back up database: %v", err)
+	}
+	if backupPath != "" {
+		log.Warnf("database backed up to '%v'.", backupPath)
+	}
+
+	for index := version; index < latestVersion; index++ {
+		migration := migrations[index]
+
+		log.Infof(2, "applying migration %v: %v", index+1, migration.description)
+
+		if err := migration.apply(db); err != nil {
+			db.Rollback()
+			return upgradeError(err, "could not apply migration %v (%v)", index+1, migration.description)
+		}
+	}
+
+	if err := db.updateSchemaVersion(latestVersion); err != nil {
+		db.Rollback()
+		return upgradeError(err, "could not update schema version")
+	}
+
+	return db.Commit()
+}
+
+// Describes an error upgrading the database. An error due to the database being
+// locked by another process is returned as is so that the upgrade is retried.
+func upgradeError(err error, format string, args ...interface{}) error {
+	if isBusy(err) {
+		return err
+	}
+
+	return fmt.Errorf(format+": %v", append(args, err)...)
+}
+
+func (db *Database) updateSchemaVersion(version uint) error {
+	sql := `CREATE TABLE IF NOT EXISTS version (
+                schema INTEGER NOT NULL
+            )`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `DELETE FROM version`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `INSERT INTO version (schema)
+           VALUES (?)`
+
+	if _, err := db.Exec(sql, version); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Copies the database file to a backup alongside it, returning the backup path.
+// A backup left by an earlier upgrade that failed is kept: as the schema version
+// is unchanged it is a backup of the same version.
+func (db *Database) backup(version uint) (string, error) {
+	backupPath := fmt.Sprintf("%v.v%v.bak", db.path, version)
+
+	if _, err := os.Stat(backupPath); err == nil {
+		log.Infof(2, "keeping existing backup '%v'.", backupPath)
+		return backupPath, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	// in WAL mode committed changes may not yet have been written to the
+	// database file. The log is copied first as the database backup marks the
+	// backup as complete.
+	if err := copyFile(db.path+"-wal", backupPath+"-wal"); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if err := copyFile(db.path, backupPath); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return backupPath, nil
+}
+
+// Copies a file via a temporary file so that the destination is never left
+// partially written.
+func copyFile(sourcePath, destPath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	temp, err := ioutil.TempFile(filepath.Dir(destPath), filepath.Base(destPath)+".")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(temp, source)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), destPath)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	return nil
+}
+
+func (db *Database) tableExists(name string) (bool, error) {
+	sql := `SELECT count(1)
+            FROM sqlite_master
+            WHERE type = 'table' AND name = ?`
+
+	rows, err := db.ExecQuery(sql, name)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	count, err := readCount(rows)
+	return count > 0, err
+}
+
+func (db *Database) columnExists(table, column string) (bool, error) {
+	rows, err := db.ExecQuery("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if rows.Err() != nil {
+			return false, rows.Err()
+		}
+
+		var index int
+		var name, dataType string
+		var notNull, primaryKey int
+		var defaultValue interface{}
+		if err := rows.Scan(&index, &name, &dataType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return false, err
+		}
+
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (db *Database) execAll(statements ...string) error {
+	for _, sql := range statements {
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrations
+
+func addFileModTime(db *Database) error {
+	exists, err := db.columnExists("file", "mod_time")
+	if err != nil || exists {
+		return err
+	}
+
+	return db.execAll(`ALTER TABLE file ADD COLUMN mod_time DATETIME`,
+		`UPDATE file SET mod_time = '1970-01-01 00:00:00' WHERE mod_time IS NULL`)
+}
+
+func addFileSizeAndMergeFileTags(db *Database) error {
+	// redundant as the unique constraint creates an identical index
+	if err := db.execAll(`DROP INDEX IF EXISTS idx_file_path`); err != nil {
+		return err
+	}
+
+	exists, err := db.columnExists("file", "size")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		// the new columns are only repaired if the file appears modified
+		if err := db.execAll(`ALTER TABLE file ADD COLUMN size INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE file ADD COLUMN is_dir BOOLEAN NOT NULL DEFAULT 0`,
+			`UPDATE file SET mod_time = '1970-01-01 00:00:00'`); err != nil {
+			return err
+		}
+	}
+
+	hasIdColumn, err := db.columnExists("file_tag", "id")
+	if err != nil {
+		return err
+	}
+	hasExplicit, err := db.tableExists("explicit_file_tag")
+	if err != nil {
+		return err
+	}
+	hasImplicit, err := db.tableExists("implicit_file_tag")
+	if err != nil {
+		return err
+	}
+
+	if !hasIdColumn && !hasExplicit && !hasImplicit {
+		return nil
+	}
+
+	// drop the id column in favour of a compound key
+	if err := db.execAll(`CREATE TABLE file_tag_temp (
+                              file_id INTEGER NOT NULL,
+                              tag_id INTEGER NOT NULL,
+                              PRIMARY KEY (file_id, tag_id),
+                              FOREIGN KEY (file_id) REFERENCES file(id),
+                              FOREIGN KEY (tag_id) REFERENCES tag(id)
+                          )`,
+		`INSERT OR IGNORE INTO file_tag_temp SELECT file_id, tag_id FROM file_tag`,
+		`DROP TABLE file_tag`,
+		`ALTER TABLE file_tag_temp RENAME TO file_tag`); err != nil {
+		return err
+	}
+
+	// the implicit and explicit tables were merged back into file_tag
+	if hasExplicit {
+		if err := db.execAll(`INSERT OR IGNORE INTO file_tag SELECT file_id, tag_id FROM explicit_file_tag`,
+			`DROP TABLE explicit_file_tag`); err != nil {
+			return err
+		}
+	}
+	if hasImplicit {
+		if err := db.execAll(`INSERT OR IGNORE INTO file_tag SELECT file_id, tag_id FROM implicit_file_tag`,
+			`DROP TABLE implicit_file_tag`); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addFileTagValues(db *Database) error {
+	exists, err := db.columnExists("file_tag", "value_id")
+	if err != nil || exists {
+		return err
+	}
+
+	return db.execAll(`CREATE TABLE file_tag_temp (
+                           file_id INTEGER NOT NULL,
+                           tag_id INTEGER NOT NULL,
+                           value_id INTEGER NOT NULL,
+                           PRIMARY KEY (file_id, tag_id, value_id),
+                           FOREIGN KEY (file_id) REFERENCES file(id),
+                           FOREIGN KEY (tag_id) REFERENCES tag(id)
+                           FOREIGN KEY (value_id) REFERENCES value(id)
+                       )`,
+		`INSERT INTO file_tag_temp SELECT file_id, tag_id, 0 FROM file_tag`,
+		`DROP TABLE file_tag`,
+		`ALTER TABLE file_tag_temp RENAME TO file_tag`,
+		`CREATE INDEX IF NOT EXISTS idx_file_tag_file_id ON file_tag(file_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_file_tag_tag_id ON file_tag(tag_id)`)
+}
+
+func addImplications(db *Database) error {
+	exists, err := db.tableExists("implication")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return db.CreateImplicationTable()
+	}
+
+	// implications saved by v0.3.0 and earlier are carried over
+	return db.execAll(`ALTER TABLE implication RENAME TO implication_old`,
+		`CREATE TABLE implication (
+             tag_id INTEGER NOT NULL,
+             implied_tag_id INTEGER NOT NULL,
+             PRIMARY KEY (tag_id, implied_tag_id),
+             FOREIGN KEY (tag_id) REFERENCES tag(id),
+             FOREIGN KEY (implied_tag_id) REFERENCES tag(id)
+         )`,
+		`INSERT OR IGNORE INTO implication
+         SELECT tag_id, implied_tag_id
+         FROM implication_old
+         WHERE tag_id != implied_tag_id`,
+		`DROP TABLE implication_old`)
+}
+
+func addQueryNames(db *Database) error {
+	exists, err := db.tableExists("query")
+	if err != nil || !exists {
+		// the table is created with the column
+		return err
+	}
+
+	exists, err = db.columnExists("query", "name")
+	if err != nil || exists {
+		return err
+	}
+
+	return db.execAll(`ALTER TABLE query ADD COLUMN name TEXT`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_query_name ON query(name)`)
+}
+
+func addTagSettings(db *Database) error {
+	return db.CreateTagSettingTable()
+}
+
+func addCoveringFileTagIndex(db *Database) error {
+	return db.execAll(`DROP INDEX IF EXISTS idx_file_tag_tag_id`,
+		`CREATE INDEX IF NOT EXISTS idx_file_tag_tag_id_file_id ON file_tag(tag_id, file_id, value_id)`)
+}
+
+// Comparisons such as 'size=3' in saved queries now match file attributes, so
+// those that compare a tag with an attribute's name are rewritten to escape it.
+func escapeAttributeTagsInQueries(db *Database) error {
+	exists, err := db.tableExists("query")
+	if err != nil || !exists {
+		return err
+	}
+
+	tags, err := db.Tags()
+	if err != nil {
+		return err
+	}
+
+	attributeTagNames := make(map[string]bool)
+	for _, tag := range tags {
+		if query.IsAttributeName(tag.Name) {
+			attributeTagNames[tag.Name] = true
+		}
+	}
+	if len(attributeTagNames) == 0 {
+		return nil
+	}
+
+	queries, err := db.Queries()
+	if err != nil {
+		return err
+	}
+
+	for _, savedQuery := range queries {
+		text, err := query.EscapeAttributeTags(savedQuery.Text, func(name string) bool {
+			return attributeTagNames[name]
+		})
+		if err != nil {
+			log.Warnf("could not check saved query '%v' for tags named like file attributes: %v", savedQuery.Text, err)
+			continue
+		}
+		if text == savedQuery.Text {
+			continue
+		}
+
+		if _, err := db.RenameQuery(savedQuery.Text, text); err != nil {
+			return err
+		}
+
+		log.Warnf("saved query '%v' rewritten as '%v': tags named like file attributes must now be escaped.", savedQuery.Text, text)
+	}
+
+	return nil
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/mattn/go-sqlite3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpgradeFromUnversionedDatabase(test *testing.T) {
+	// set-up
+
+	path, cleanUp := testDatabasePath(test)
+	defer cleanUp()
+
+	connection, err := sql.Open("sqlite3", path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	for _, statement := range []string{
+		`CREATE TABLE tag (id INTEGER PRIMARY KEY, name TEXT NOT NULL)`,
+		`CREATE TABLE file (id INTEGER PRIMARY KEY, directory TEXT NOT NULL, name TEXT NOT NULL, fingerprint TEXT NOT NULL)`,
+		`CREATE TABLE file_tag (id INTEGER PRIMARY KEY, file_id INTEGER NOT NULL, tag_id INTEGER NOT NULL)`,
+		`INSERT INTO tag (id, name) VALUES (1, 'music')`,
+		`INSERT INTO file (id, directory, name, fingerprint) VALUES (1, '/tmp', 'a', 'abc')`,
+		`INSERT INTO file_tag (id, file_id, tag_id) VALUES (1, 1, 1)`,
+	} {
+		if _, err := connection.Exec(statement); err != nil {
+			test.Fatal(err)
+		}
+	}
+	connection.Close()
+
+	// test
+
+	db, err := OpenAt(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer db.Close()
+
+	// validate
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if version != LatestSchemaVersion() {
+		test.Fatalf("Expected schema version %v but was %v.", LatestSchemaVersion(), version)
+	}
+
+	fileTagCount, err := db.FileTagCount()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if fileTagCount != 1 {
+		test.Fatalf("Expected 1 file-tag but there were %v.", fileTagCount)
+	}
+
+	if _, err := os.Stat(path + ".v0.bak"); err != nil {
+		test.Fatalf("Database was not backed up: %v", err)
+	}
+}
+
+func TestNewerSchemaVersionIsRefused(test *testing.T) {
+	// set-up
+
+	path, cleanUp := testDatabasePath(test)
+	defer cleanUp()
+
+	db, err := OpenAt(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if err := db.updateSchemaVersion(LatestSchemaVersion() + 1); err != nil {
+		test.Fatal(err)
+	}
+	if err := db.Commit(); err != nil {
+		test.Fatal(err)
+	}
+	db.Close()
+
+	// test
+
+	db, err = OpenAt(path)
+
+	// validate
+
+	if err == nil {
+		db.Close()
+		test.Fatal("Database with a newer schema version was opened.")
+	}
+	if !strings.Contains(err.Error(), "newer") {
+		test.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestFailedMigrationIsRetried(test *testing.T) {
+	// set-up
+
+	path, cleanUp := testDatabasePath(test)
+	defer cleanUp()
+
+	db, err := OpenAt(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	db.Close()
+
+	previousVersion := LatestSchemaVersion()
+
+	savedMigrations := migrations
+	defer func() { migrations = savedMigrations }()
+
+	migrations = append(savedMigrations[:len(savedMigrations):len(savedMigrations)], migration{"fail", func(db *Database) error {
+		return errors.New("migration failed")
+	}})
+
+	// test
+
+	if db, err := OpenAt(path); err == nil {
+		db.Close()
+		test.Fatal("Failing migration succeeded.")
+	}
+
+	migrations[len(migrations)-1] = migration{"succeed", func(db *Database) error {
+		return nil
+	}}
+
+	db, err = OpenAt(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer db.Close()
+
+	// validate
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if version != previousVersion+1 {
+		test.Fatalf("Expected schema version %v but was %v.", previousVersion+1, version)
+	}
+
+	if _, err := os.Stat(fmt.Sprintf("%v.v%v.bak", path, previousVersion)); err != nil {
+		test.Fatalf("Database backup is missing: %v", err)
+	}
+}
+
+func TestBusyMigrationIsRetried(test *testing.T) {
+	// set-up
+
+	path, cleanUp := testDatabasePath(test)
+	defer cleanUp()
+
+	db, err := OpenAt(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	db.Close()
+
+	previousVersion := LatestSchemaVersion()
+
+	savedMigrations := migrations
+	defer func() { migrations = savedMigrations }()
+
+	attempts := 0
+	migrations = append(savedMigrations[:len(savedMigrations):len(savedMigrations)], migration{"busy", func(db *Database) error {
+		attempts++
+		if attempts == 1 {
+			return sqlite3.Error{Code: sqlite3.ErrBusy}
+		}
+
+		return nil
+	}})
+
+	// test
+
+	db, err = OpenAt(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer db.Close()
+
+	// validate
+
+	if attempts != 2 {
+		test.Fatalf("Expected the migration to be applied twice but was applied %v times.", attempts)
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if version != previousVersion+1 {
+		test.Fatalf("Expected schema version %v but was %v.", previousVersion+1, version)
+	}
+}
+
+// unexported
+
+func testDatabasePath(test *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "tmsu-upgrade-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	return filepath.Join(dir, "db"), func() { os.RemoveAll(dir) }
+}
+
+func TestUpgradeEscapesAttributeTagsInQueries(test *testing.T) {
+	// set-up
+
+	path, cleanUp := testDatabasePath(test)
+	defer cleanUp()
+
+	db, err := OpenAt(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := db.InsertTag("size"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := db.InsertQuery("size=3 and name=a", ""); err != nil {
+		test.Fatal(err)
+	}
+	if err := db.updateSchemaVersion(LatestSchemaVersion() - 1); err != nil {
+		test.Fatal(err)
+	}
+	if err := db.Commit(); err != nil {
+		test.Fatal(err)
+	}
+	db.Close()
+
+	// test
+
+	db, err = OpenAt(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer db.Close()
+
+	// validate
+
+	queries, err := db.Queries()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(queries) != 1 || queries[0].Text != `\size=3 and name=a` {
+		test.Fatalf("Unexpected saved queries %v.", queries)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"tmsu/common/fingerprint"
 	"tmsu/entities"
 	"tmsu/query"
+	"tmsu/storage/database"
 )
 
 // Retrieves the total number of tracked files.
@@ -33,12 +34,31 @@ func (storage *Storage) FileCount() (uint, error) {
 
 // The complete set of tracked files.
 func (storage *Storage) Files() (entities.Files, error) {
-	return storage.Db.Files()
+	files, err := storage.Db.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.resolveFiles(files), nil
+}
+
+// Calls the function for each of the selected tracked files in turn without
+// first retrieving the complete set. Stops at, and returns, the first error the
+// function returns.
+func (storage *Storage) ForEachFile(selection database.FileSelection, fn func(*entities.File) error) error {
+	return storage.Db.ForEachFile(selection, func(file *entities.File) error {
+		return fn(storage.resolveFile(file))
+	})
 }
 
 // Retrieves a specific file.
 func (storage *Storage) File(id uint) (*entities.File, error) {
-	return storage.Db.File(id)
+	file, err := storage.Db.File(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.resolveFile(file), nil
 }
 
 // Retrieves the file with the specified path.
@@ -48,12 +68,32 @@ func (storage *Storage) FileByPath(path string) (*entities.File, error) {
 		return nil, fmt.Errorf("%v: could not retrieve absolute path: %v", path, err)
 	}
 
-	return storage.Db.FileByPath(absPath)
+	file, err := storage.Db.FileByPath(storage.storedPath(absPath))
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.resolveFile(file), nil
 }
 
 // Retrieves all files that are under the specified directory.
 func (storage *Storage) FilesByDirectory(path string) (entities.Files, error) {
-	return storage.Db.FilesByDirectory(path)
+	files, err := storage.Db.FilesByDirectory(storage.storedPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if storage.containsRoot(path) {
+		// every file stored relative to the root is under the directory
+		relativeFiles, err := storage.Db.FilesWithRelativePaths()
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, relativeFiles...)
+	}
+
+	return storage.resolveFiles(files), nil
 }
 
 // Retrieves all file that are under the specified directories.
@@ -61,7 +101,7 @@ func (storage *Storage) FilesByDirectories(paths []string) (entities.Files, erro
 	files := make(entities.Files, 0, 100)
 
 	for _, path := range paths {
-		pathFiles, err := storage.Db.FilesByDirectory(path)
+		pathFiles, err := storage.FilesByDirectory(path)
 		if err != nil {
 			return nil, fmt.Errorf("'%v': could not retrieve files for directory: %v", path, err)
 		}
@@ -79,49 +119,145 @@ func (storage *Storage) FileCountByFingerprint(fingerprint fingerprint.Fingerpri
 
 // Retrieves the set of files with the specified fingerprint.
 func (storage *Storage) FilesByFingerprint(fingerprint fingerprint.Fingerprint) (entities.Files, error) {
-	return storage.Db.FilesByFingerprint(fingerprint)
+	files, err := storage.Db.FilesByFingerprint(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.resolveFiles(files), nil
 }
 
 // Retrieves the count of files with the specified tags.
 func (storage *Storage) FileCountWithTags(tagNames []string) (uint, error) {
 	expression := query.HasAll(tagNames)
-	return storage.Db.QueryFileCount(expression)
+	return storage.QueryFileCount(expression)
 }
 
 // Retrieves the set of untagged files.
 func (storage *Storage) UntaggedFiles() (entities.Files, error) {
-	return storage.Db.UntaggedFiles()
+	files, err := storage.Db.UntaggedFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.resolveFiles(files), nil
 }
 
 // Retrieves the set of files with the specified tags.
 func (storage *Storage) FilesWithTags(tagNames []string) (entities.Files, error) {
 	expression := query.HasAll(tagNames)
-	return storage.Db.QueryFiles(expression)
+	return storage.QueryFiles(expression)
 }
 
 // Retrieves the count of files that match the specified query.
+// Files are matched on both their explicit tags and the tags these imply.
 func (storage *Storage) QueryFileCount(expression query.Expression) (uint, error) {
-	return storage.Db.QueryFileCount(expression)
+	expression, valueTypes, err := storage.prepareQuery(expression)
+	if err != nil {
+		return 0, err
+	}
+
+	return storage.Db.QueryFileCount(expression, valueTypes)
 }
 
 // Retrieves the set of files that match the specified query.
+// Files are matched on both their explicit tags and the tags these imply.
 func (storage *Storage) QueryFiles(expression query.Expression) (entities.Files, error) {
-	return storage.Db.QueryFiles(expression)
+	expression, valueTypes, err := storage.prepareQuery(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := storage.Db.QueryFiles(expression, valueTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.resolveFiles(files), nil
+}
+
+// Calls the function for each of the selected files that match the specified
+// query in turn without first retrieving the complete set. Stops at, and returns,
+// the first error the function returns.
+func (storage *Storage) ForEachQueryFile(expression query.Expression, selection database.FileSelection, fn func(*entities.File) error) error {
+	expression, valueTypes, err := storage.prepareQuery(expression)
+	if err != nil {
+		return err
+	}
+
+	return storage.Db.ForEachQueryFile(expression, valueTypes, selection, func(file *entities.File) error {
+		return fn(storage.resolveFile(file))
+	})
+}
+
+// Calls the function for each of the files matching at least the minimum number
+// of the query's terms, along with the number of terms it matches, in order of
+// decreasing score. The terms are the operands of the query's top-level 'and's,
+// so a saved query is a single term.
+func (storage *Storage) ForEachRankedFile(expression query.Expression, minimumScore uint, fn func(*entities.File, uint) error) error {
+	terms := query.Terms(expression)
+
+	var valueTypes map[string]string
+	for index, term := range terms {
+		var err error
+		terms[index], valueTypes, err = storage.prepareQuery(term)
+		if err != nil {
+			return err
+		}
+	}
+
+	return storage.Db.ForEachRankedFile(terms, minimumScore, valueTypes, func(file *entities.File, score uint) error {
+		return fn(storage.resolveFile(file), score)
+	})
+}
+
+// Explains how the selected files matching the specified query are retrieved.
+// Returns the query as it is evaluated, once prepared exactly as for
+// ForEachQueryFile, along with the SQL, and its parameters, used to retrieve the
+// files.
+func (storage *Storage) ExplainQuery(expression query.Expression, selection database.FileSelection) (query.Expression, string, []interface{}, error) {
+	expression, valueTypes, err := storage.prepareQuery(expression)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	sql, params := storage.Db.QueryFilesSql(expression, valueTypes, selection)
+
+	return expression, sql, params, nil
 }
 
 // Retrieves the sets of duplicate files within the database.
 func (storage *Storage) DuplicateFiles() ([]entities.Files, error) {
-	return storage.Db.DuplicateFiles()
+	fileSets, err := storage.Db.DuplicateFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fileSet := range fileSets {
+		storage.resolveFiles(fileSet)
+	}
+
+	return fileSets, nil
 }
 
 // Adds a file to the database.
 func (storage *Storage) AddFile(path string, fingerprint fingerprint.Fingerprint, modTime time.Time, size int64, isDir bool) (*entities.File, error) {
-	return storage.Db.InsertFile(path, fingerprint, modTime, size, isDir)
+	file, err := storage.Db.InsertFile(storage.storedPath(path), fingerprint, modTime, size, isDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.resolveFile(file), nil
 }
 
 // Updates a file in the database.
 func (storage *Storage) UpdateFile(fileId uint, path string, fingerprint fingerprint.Fingerprint, modTime time.Time, size int64, isDir bool) (*entities.File, error) {
-	return storage.Db.UpdateFile(fileId, path, fingerprint, modTime, size, isDir)
+	file, err := storage.Db.UpdateFile(fileId, storage.storedPath(path), fingerprint, modTime, size, isDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.resolveFile(file), nil
 }
 
 // Deletes a file from the database.
@@ -133,3 +269,44 @@ func (storage *Storage) DeleteFile(fileId uint) error {
 func (storage *Storage) DeleteUntaggedFiles() error {
 	return storage.Db.DeleteUntaggedFiles()
 }
+
+// unexported
+
+// Prepares a query for the database: saved queries are expanded, the query is
+// simplified, folding away tags that do not exist, tag implications are
+// expanded, the values are validated against the value types of the tags, which
+// are returned, and the query is planned.
+func (storage *Storage) prepareQuery(expression query.Expression) (query.Expression, map[string]string, error) {
+	expression, err := storage.ExpandMacros(expression)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tags, err := storage.TagsByNames(query.TagNames(expression))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	expression = query.Simplify(expression, tags.ContainsName)
+
+	expression, err = storage.expandImplications(expression)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not expand tag implications: %v", err)
+	}
+
+	valueTypes, err := storage.ValueTypes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not retrieve value types: %v", err)
+	}
+
+	if err := query.ValidateValues(expression, valueTypes); err != nil {
+		return nil, nil, err
+	}
+
+	expression, err = storage.planQuery(expression)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not plan query: %v", err)
+	}
+
+	return expression, valueTypes, nil
+}
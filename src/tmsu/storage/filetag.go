@@ -66,13 +66,23 @@ func (storage *Storage) FileTagsByFileId(fileId uint) (entities.FileTags, error)
 	return storage.Db.FileTagsByFileId(fileId)
 }
 
-// Adds a file tag.
+// Adds a file tag. The value must be of the type declared for the tag.
 func (storage *Storage) AddFileTag(fileId, tagId, valueId uint) (*entities.FileTag, error) {
+	if valueId != 0 {
+		if err := storage.checkTagValue(tagId, valueId); err != nil {
+			return nil, err
+		}
+	}
+
+	storage.clearTagFileCounts()
+
 	return storage.Db.AddFileTag(fileId, tagId, valueId)
 }
 
 // Delete file tag.
 func (storage *Storage) DeleteFileTag(fileId, tagId, valueId uint) error {
+	storage.clearTagFileCounts()
+
 	if err := storage.Db.DeleteFileTag(fileId, tagId, valueId); err != nil {
 		return err
 	}
@@ -90,6 +100,8 @@ func (storage *Storage) DeleteFileTag(fileId, tagId, valueId uint) error {
 
 // Deletes all of the file tags for the specified file.
 func (storage *Storage) DeleteFileTagsByFileId(fileId uint) error {
+	storage.clearTagFileCounts()
+
 	if err := storage.Db.DeleteFileTagsByFileId(fileId); err != nil {
 		return err
 	}
@@ -107,6 +119,8 @@ func (storage *Storage) DeleteFileTagsByFileId(fileId uint) error {
 
 // Deletes all of the file tags for the specified tag.
 func (storage *Storage) DeleteFileTagsByTagId(tagId uint) error {
+	storage.clearTagFileCounts()
+
 	if err := storage.Db.DeleteFileTagsByTagId(tagId); err != nil {
 		return err
 	}
@@ -124,6 +138,8 @@ func (storage *Storage) DeleteFileTagsByTagId(tagId uint) error {
 
 // Copies file tags from one tag to another.
 func (storage *Storage) CopyFileTags(sourceTagId, destTagId uint) error {
+	storage.clearTagFileCounts()
+
 	return storage.Db.CopyFileTags(sourceTagId, destTagId)
 }
 
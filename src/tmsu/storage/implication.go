@@ -0,0 +1,222 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"errors"
+	"sort"
+	"tmsu/entities"
+	"tmsu/query"
+)
+
+// The complete set of tag implications.
+func (storage *Storage) Implications() (entities.Implications, error) {
+	return storage.Db.Implications()
+}
+
+// Adds an implication such that files tagged with the first tag are also
+// considered to be tagged with the second.
+func (storage *Storage) AddImplication(tagId, impliedTagId uint) error {
+	if tagId == impliedTagId {
+		return errors.New("a tag cannot imply itself.")
+	}
+
+	implications, err := storage.Db.Implications()
+	if err != nil {
+		return err
+	}
+
+	if createsCycle(implications, tagId, impliedTagId) {
+		return errors.New("implication would create a cycle.")
+	}
+
+	return storage.Db.AddImplication(tagId, impliedTagId)
+}
+
+// Removes the specified implication.
+func (storage *Storage) RemoveImplication(tagId, impliedTagId uint) error {
+	return storage.Db.DeleteImplication(tagId, impliedTagId)
+}
+
+// Retrieves the set of tags implied, directly or indirectly, by the specified
+// tags. The specified tags themselves are not included.
+func (storage *Storage) ImpliedTags(tagIds []uint) (entities.Tags, error) {
+	implications, err := storage.Db.Implications()
+	if err != nil {
+		return nil, err
+	}
+
+	return impliedTags(implications, tagIds), nil
+}
+
+// unexported
+
+// Re-points the implications involving the source tag to the destination tag.
+// Implications that would have the destination tag imply itself, that already
+// exist or that would create a cycle are dropped.
+func (storage Storage) moveImplications(sourceTagId, destTagId uint) error {
+	implications, err := storage.Db.Implications()
+	if err != nil {
+		return err
+	}
+
+	for _, implication := range implications {
+		tagId, impliedTagId := implication.ImplyingTag.Id, implication.ImpliedTag.Id
+		switch sourceTagId {
+		case tagId:
+			tagId = destTagId
+		case impliedTagId:
+			impliedTagId = destTagId
+		default:
+			continue
+		}
+
+		if err := storage.Db.DeleteImplication(implication.ImplyingTag.Id, implication.ImpliedTag.Id); err != nil {
+			return err
+		}
+
+		if tagId == impliedTagId {
+			continue
+		}
+
+		current, err := storage.Db.Implications()
+		if err != nil {
+			return err
+		}
+		if createsCycle(current, tagId, impliedTagId) {
+			continue
+		}
+
+		// an implication that already exists is ignored
+		if err := storage.Db.AddImplication(tagId, impliedTagId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Determines whether the implication would complete a cycle, which it would if
+// the implied tag already implies, directly or indirectly, the implying tag.
+func createsCycle(implications entities.Implications, tagId, impliedTagId uint) bool {
+	for _, tag := range impliedTags(implications, []uint{impliedTagId}) {
+		if tag.Id == tagId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func impliedTags(implications entities.Implications, tagIds []uint) entities.Tags {
+	visited := make(map[uint]bool, len(tagIds))
+	for _, tagId := range tagIds {
+		visited[tagId] = true
+	}
+
+	tags := make(entities.Tags, 0, 10)
+
+	pending := tagIds
+	for len(pending) > 0 {
+		tagId := pending[0]
+		pending = pending[1:]
+
+		for _, implication := range implications {
+			if implication.ImplyingTag.Id != tagId || visited[implication.ImpliedTag.Id] {
+				continue
+			}
+
+			visited[implication.ImpliedTag.Id] = true
+			tag := implication.ImpliedTag
+			tags = append(tags, &tag)
+			pending = append(pending, tag.Id)
+		}
+	}
+
+	return tags
+}
+
+// Rewrites the expression so that each tag also matches the tags that imply it.
+func (storage *Storage) expandImplications(expression query.Expression) (query.Expression, error) {
+	implications, err := storage.Db.Implications()
+	if err != nil {
+		return nil, err
+	}
+	if len(implications) == 0 {
+		return expression, nil
+	}
+
+	implyingTagNames := make(map[string][]string, len(implications))
+	for _, implication := range implications {
+		impliedName := implication.ImpliedTag.Name
+		implyingTagNames[impliedName] = append(implyingTagNames[impliedName], implication.ImplyingTag.Name)
+	}
+
+	return expandBranch(expression, implyingTagNames), nil
+}
+
+func expandBranch(expression query.Expression, implyingTagNames map[string][]string) query.Expression {
+	switch exp := expression.(type) {
+	case query.TagExpression:
+		visited := map[string]bool{exp.Name: true}
+		var result query.Expression = exp
+
+		pending := []string{exp.Name}
+		for len(pending) > 0 {
+			name := pending[0]
+			pending = pending[1:]
+
+			for _, implyingName := range implyingTagNames[name] {
+				if visited[implyingName] {
+					continue
+				}
+
+				visited[implyingName] = true
+				result = query.OrExpression{result, query.TagExpression{implyingName}}
+				pending = append(pending, implyingName)
+			}
+		}
+
+		return result
+	case query.TagPatternExpression:
+		// implied tags that match the pattern are expanded as if named
+		impliedNames := make([]string, 0, len(implyingTagNames))
+		for impliedName := range implyingTagNames {
+			if query.MatchTagPattern(exp.Pattern, impliedName) {
+				impliedNames = append(impliedNames, impliedName)
+			}
+		}
+		sort.Strings(impliedNames)
+
+		var result query.Expression = exp
+		for _, impliedName := range impliedNames {
+			result = query.OrExpression{result, expandBranch(query.TagExpression{impliedName}, implyingTagNames)}
+		}
+
+		return result
+	case query.NotExpression:
+		return query.NotExpression{expandBranch(exp.Operand, implyingTagNames)}
+	case query.AndExpression:
+		return query.AndExpression{expandBranch(exp.LeftOperand, implyingTagNames), expandBranch(exp.RightOperand, implyingTagNames)}
+	case query.OrExpression:
+		return query.OrExpression{expandBranch(exp.LeftOperand, implyingTagNames), expandBranch(exp.RightOperand, implyingTagNames)}
+	default:
+		// values are not implied so comparisons are left as they are
+		return expression
+	}
+}
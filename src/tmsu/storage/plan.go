@@ -0,0 +1,187 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"tmsu/query"
+)
+
+// Queries are planned by ordering the operands of each 'and' so that the most
+// selective, i.e. those expected to match the fewest files, are evaluated
+// first. The number of files matched by an operand is estimated from the number
+// of files each tag is applied to, which is cached until the file tags change
+// or the transaction is rolled back.
+
+// unexported
+
+// Orders the operands of the expression's 'and' operators by selectivity.
+func (storage *Storage) planQuery(expression query.Expression) (query.Expression, error) {
+	fileCount, err := storage.Db.FileCount()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve file count: %v", err)
+	}
+
+	tags, err := storage.TagsByNames(query.TagNames(expression))
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	tagFileCounts := make(map[string]uint, len(tags))
+	for _, tag := range tags {
+		count, err := storage.tagFileCount(tag.Id)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve file count for tag '%v': %v", tag.Name, err)
+		}
+
+		tagFileCounts[tag.Name] = count
+	}
+
+	planner := planner{tagFileCounts, fileCount}
+
+	return planner.order(expression), nil
+}
+
+// Retrieves the number of files the tag is applied to from the cache, if
+// present, or else from the database.
+func (storage Storage) tagFileCount(tagId uint) (uint, error) {
+	if count, ok := storage.tagFileCounts[tagId]; ok {
+		return count, nil
+	}
+
+	count, err := storage.Db.FileTagCountByTagId(tagId)
+	if err != nil {
+		return 0, err
+	}
+
+	storage.tagFileCounts[tagId] = count
+
+	return count, nil
+}
+
+// Empties the cache of tag file counts. The map itself is kept as it is shared
+// by copies of the storage.
+func (storage Storage) clearTagFileCounts() {
+	for tagId := range storage.tagFileCounts {
+		delete(storage.tagFileCounts, tagId)
+	}
+}
+
+type planner struct {
+	tagFileCounts map[string]uint
+	fileCount     uint
+}
+
+// Rebuilds the expression with the operands of each 'and' in order of
+// increasing estimated file count. Operands with the same estimate keep their
+// original order.
+func (planner planner) order(expression query.Expression) query.Expression {
+	switch exp := expression.(type) {
+	case query.AndExpression:
+		operands := make(plannedOperands, 0, 10)
+		for _, operand := range query.Terms(exp) {
+			operand = planner.order(operand)
+			operands = append(operands, plannedOperand{operand, planner.estimate(operand)})
+		}
+
+		sort.Stable(operands)
+
+		ordered := operands[0].expression
+		for _, operand := range operands[1:] {
+			ordered = query.AndExpression{ordered, operand.expression}
+		}
+
+		return ordered
+	case query.OrExpression:
+		return query.OrExpression{planner.order(exp.LeftOperand), planner.order(exp.RightOperand)}
+	case query.NotExpression:
+		return query.NotExpression{planner.order(exp.Operand)}
+	}
+
+	return expression
+}
+
+// Estimates the number of files the expression matches. Comparisons of a tag's
+// values are assumed to match every file with that tag and expressions that
+// cannot be estimated are assumed to match every file.
+func (planner planner) estimate(expression query.Expression) uint {
+	switch exp := expression.(type) {
+	case query.TagExpression:
+		return planner.tagFileCount(exp.Name)
+	case query.ComparisonExpression:
+		return planner.tagFileCount(exp.Tag.Name)
+	case query.RangeExpression:
+		return planner.tagFileCount(exp.Tag.Name)
+	case query.SetExpression:
+		return planner.tagFileCount(exp.Tag.Name)
+	case query.NotExpression:
+		return planner.fileCount - planner.estimate(exp.Operand)
+	case query.AndExpression:
+		left := planner.estimate(exp.LeftOperand)
+		right := planner.estimate(exp.RightOperand)
+		if right < left {
+			return right
+		}
+
+		return left
+	case query.OrExpression:
+		count := planner.estimate(exp.LeftOperand) + planner.estimate(exp.RightOperand)
+		if count > planner.fileCount {
+			return planner.fileCount
+		}
+
+		return count
+	case query.ConstantExpression:
+		if !exp.Value {
+			return 0
+		}
+	}
+
+	return planner.fileCount
+}
+
+// The number of files with the tag, which is assumed to be no more than the
+// number of files as a tag may be applied to a file with several values.
+func (planner planner) tagFileCount(tagName string) uint {
+	count := planner.tagFileCounts[tagName]
+	if count > planner.fileCount {
+		return planner.fileCount
+	}
+
+	return count
+}
+
+type plannedOperand struct {
+	expression query.Expression
+	estimate   uint
+}
+
+type plannedOperands []plannedOperand
+
+func (operands plannedOperands) Len() int {
+	return len(operands)
+}
+
+func (operands plannedOperands) Swap(i, j int) {
+	operands[i], operands[j] = operands[j], operands[i]
+}
+
+func (operands plannedOperands) Less(i, j int) bool {
+	return operands[i].estimate < operands[j].estimate
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/query"
+)
+
+func TestPlannerOrdersBySelectivity(test *testing.T) {
+	planner := planner{map[string]uint{"common": 10, "rare": 1, "some": 5}, 20}
+
+	for text, expected := range map[string]string{
+		"common and rare and not some":  "rare and common and not some",
+		"common and (some or rare)":     "(some or rare) and common",
+		"unknown and common":            "unknown and common",
+		"common and false and rare":     "false and rare and common",
+		"type=file and common and rare": "rare and common and type=file"} {
+		expression, err := query.Parse(text)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if planned := planner.order(expression).String(); planned != expected {
+			test.Fatalf("Expected '%v' to be planned as '%v' but was '%v'.", text, expected, planned)
+		}
+	}
+}
+
+func TestPlanQueryCachesTagFileCounts(test *testing.T) {
+	// set-up
+
+	dir, err := ioutil.TempDir("", "tmsu-plan-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := OpenAt(filepath.Join(dir, "db"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileIds := make([]uint, 0, 3)
+	for _, path := range []string{"/tmp/a", "/tmp/b", "/tmp/c"} {
+		file, err := store.AddFile(path, fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		fileIds = append(fileIds, file.Id)
+	}
+
+	tagCommon, err := store.AddTag("common")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagRare, err := store.AddTag("rare")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	for _, fileId := range fileIds[:2] {
+		if _, err := store.AddFileTag(fileId, tagCommon.Id, 0); err != nil {
+			test.Fatal(err)
+		}
+	}
+	if _, err := store.AddFileTag(fileIds[0], tagRare.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	expression, err := query.Parse("common and rare")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	planned, err := store.planQuery(expression)
+	if err != nil {
+		test.Fatal(err)
+	}
+	cachedCount := store.tagFileCounts[tagCommon.Id]
+
+	for _, fileId := range fileIds {
+		if _, err := store.AddFileTag(fileId, tagRare.Id, 0); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	replanned, err := store.planQuery(expression)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if cachedCount != 2 {
+		test.Fatalf("Expected file count of 2 to be cached for tag 'common' but was %v.", cachedCount)
+	}
+	if planned.String() != "rare and common" {
+		test.Fatalf("Unexpected plan '%v'.", planned)
+	}
+	if replanned.String() != "common and rare" {
+		test.Fatalf("Cached file counts were not invalidated: plan was '%v'.", replanned)
+	}
+}
@@ -18,7 +18,9 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package storage
 
 import (
+	"fmt"
 	"tmsu/entities"
+	"tmsu/query"
 )
 
 // The complete set of queries.
@@ -31,12 +33,239 @@ func (storage *Storage) Query(text string) (*entities.Query, error) {
 	return storage.Db.Query(text)
 }
 
-// Adds a query to the database.
-func (storage *Storage) AddQuery(text string) (*entities.Query, error) {
-	return storage.Db.InsertQuery(text)
+// Retrieves the query with the specified name.
+func (storage *Storage) QueryByName(name string) (*entities.Query, error) {
+	return storage.Db.QueryByName(name)
 }
 
-// Removes a query from the database.
+// Adds a query to the database. Named queries can be referred to from other
+// queries, e.g. '@name'. An empty name leaves the query unnamed.
+func (storage *Storage) AddQuery(text, name string) (*entities.Query, error) {
+	if name != "" {
+		if err := validateQueryName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := storage.checkMacros(text, name); err != nil {
+		return nil, err
+	}
+
+	return storage.Db.InsertQuery(text, name)
+}
+
+// Changes the text of the specified query.
+func (storage *Storage) RenameQuery(text, newText string) (*entities.Query, error) {
+	savedQuery, err := storage.Db.Query(text)
+	if err != nil {
+		return nil, err
+	}
+
+	name := ""
+	if savedQuery != nil {
+		name = savedQuery.Name
+	}
+
+	if err := storage.checkMacros(newText, name); err != nil {
+		return nil, err
+	}
+
+	return storage.Db.RenameQuery(text, newText)
+}
+
+// Removes a query from the database. A named query cannot be removed whilst
+// other queries refer to it.
 func (storage *Storage) DeleteQuery(text string) error {
+	savedQuery, err := storage.Db.Query(text)
+	if err != nil {
+		return err
+	}
+
+	if savedQuery != nil && savedQuery.Name != "" {
+		queries, err := storage.queriesUsingQuery(savedQuery.Name)
+		if err != nil {
+			return err
+		}
+		if len(queries) > 0 {
+			return fmt.Errorf("query '@%v' is used by query '%v'.", savedQuery.Name, queries[0].Text)
+		}
+	}
+
 	return storage.Db.DeleteQuery(text)
 }
+
+// Retrieves the saved queries that use the specified tag.
+func (storage *Storage) QueriesUsingTag(tagName string) (entities.Queries, error) {
+	queries, err := storage.Db.Queries()
+	if err != nil {
+		return nil, err
+	}
+
+	usingQueries := make(entities.Queries, 0, 10)
+	for _, savedQuery := range queries {
+		expression, err := query.Parse(savedQuery.Text)
+		if err != nil {
+			continue
+		}
+
+		if containsName(query.TagNames(expression), tagName) {
+			usingQueries = append(usingQueries, savedQuery)
+		}
+	}
+
+	return usingQueries, nil
+}
+
+// Replaces each reference to a named query, e.g. '@name', with that query.
+func (storage *Storage) ExpandMacros(expression query.Expression) (query.Expression, error) {
+	if len(query.MacroNames(expression)) == 0 {
+		return expression, nil
+	}
+
+	return query.ExpandMacros(expression, func(name string) (string, error) {
+		savedQuery, err := storage.Db.QueryByName(name)
+		if err != nil {
+			return "", fmt.Errorf("could not retrieve query '@%v': %v", name, err)
+		}
+		if savedQuery == nil {
+			return "", fmt.Errorf("no such query '@%v'.", name)
+		}
+
+		return savedQuery.Text, nil
+	})
+}
+
+// unexported
+
+// Checks that the macros within the query text refer to existing queries and
+// do not, directly or indirectly, refer back to themselves. The query text is
+// checked as though it were saved with the specified name, if any.
+func (storage *Storage) checkMacros(text, name string) error {
+	expression, err := query.Parse(text)
+	if err != nil {
+		return err
+	}
+
+	_, err = query.ExpandMacros(expression, func(macroName string) (string, error) {
+		if macroName == name {
+			return text, nil
+		}
+
+		savedQuery, err := storage.Db.QueryByName(macroName)
+		if err != nil {
+			return "", fmt.Errorf("could not retrieve query '@%v': %v", macroName, err)
+		}
+		if savedQuery == nil {
+			return "", fmt.Errorf("no such query '@%v'.", macroName)
+		}
+
+		return savedQuery.Text, nil
+	})
+
+	return err
+}
+
+// Retrieves the saved queries that refer directly to the named query. Queries
+// that do not parse cannot refer to it so are skipped.
+func (storage *Storage) queriesUsingQuery(name string) (entities.Queries, error) {
+	queries, err := storage.Db.Queries()
+	if err != nil {
+		return nil, err
+	}
+
+	usingQueries := make(entities.Queries, 0, 10)
+	for _, savedQuery := range queries {
+		expression, err := query.Parse(savedQuery.Text)
+		if err != nil {
+			continue
+		}
+
+		if containsName(query.MacroNames(expression), name) {
+			usingQueries = append(usingQueries, savedQuery)
+		}
+	}
+
+	return usingQueries, nil
+}
+
+func validateQueryName(name string) error {
+	expression, err := query.Parse("@" + name)
+	if err != nil {
+		return fmt.Errorf("invalid query name '%v': %v", name, err)
+	}
+
+	macro, ok := expression.(query.MacroExpression)
+	if !ok || macro.Name != name {
+		return fmt.Errorf("invalid query name '%v'.", name)
+	}
+
+	return nil
+}
+
+// Rewrites the saved queries that use the specified tag to use the new name.
+func (storage *Storage) renameTagInQueries(tagName, newTagName string) error {
+	queries, err := storage.QueriesUsingTag(tagName)
+	if err != nil {
+		return err
+	}
+
+	for _, savedQuery := range queries {
+		expression, err := query.Parse(savedQuery.Text)
+		if err != nil {
+			return err
+		}
+
+		newText := query.RenameTag(expression, tagName, newTagName).String()
+		if err := storage.replaceQueryText(savedQuery, newText); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Changes the text of a saved query. Should a query with the new text already
+// exist then the two are combined.
+func (storage *Storage) replaceQueryText(savedQuery *entities.Query, newText string) error {
+	if newText == savedQuery.Text {
+		return nil
+	}
+
+	existingQuery, err := storage.Db.Query(newText)
+	if err != nil {
+		return err
+	}
+	if existingQuery == nil {
+		_, err := storage.Db.RenameQuery(savedQuery.Text, newText)
+		return err
+	}
+
+	name := existingQuery.Name
+	if savedQuery.Name != "" {
+		if name != "" && name != savedQuery.Name {
+			return fmt.Errorf("saved queries '@%v' and '@%v' would become identical.", savedQuery.Name, name)
+		}
+
+		name = savedQuery.Name
+	}
+
+	if err := storage.Db.DeleteQuery(savedQuery.Text); err != nil {
+		return err
+	}
+	if err := storage.Db.DeleteQuery(existingQuery.Text); err != nil {
+		return err
+	}
+
+	_, err = storage.Db.InsertQuery(newText, name)
+	return err
+}
+
+func containsName(names []string, name string) bool {
+	for _, candidate := range names {
+		if candidate == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"tmsu/entities"
+)
+
+// Local databases store the paths of files within their root directory relative
+// to it so that the directory tree can be moved, or mounted elsewhere, without
+// breaking the database. Paths outside of the root are stored absolute.
+
+// unexported
+
+// Converts an absolute path to the form in which it is stored.
+func (storage *Storage) storedPath(path string) string {
+	if storage.RootPath == "" || !filepath.IsAbs(path) {
+		return path
+	}
+
+	relPath, err := filepath.Rel(storage.RootPath, path)
+	if err != nil || relPath == "." || relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return path
+	}
+
+	return relPath
+}
+
+// Converts a stored path to an absolute path.
+func (storage *Storage) resolvedPath(path string) string {
+	if storage.RootPath == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(storage.RootPath, path)
+}
+
+// Determines whether the specified absolute path is the root directory or one
+// of its ancestors.
+func (storage *Storage) containsRoot(path string) bool {
+	if storage.RootPath == "" {
+		return false
+	}
+
+	relPath, err := filepath.Rel(path, storage.RootPath)
+	return err == nil && relPath != ".." && !strings.HasPrefix(relPath, "../")
+}
+
+func (storage *Storage) resolveFile(file *entities.File) *entities.File {
+	if file != nil {
+		file.Directory = storage.resolvedPath(file.Directory)
+	}
+
+	return file
+}
+
+func (storage *Storage) resolveFiles(files entities.Files) entities.Files {
+	if storage.RootPath == "" {
+		return files
+	}
+
+	for _, file := range files {
+		storage.resolveFile(file)
+	}
+
+	sort.Sort(filesByPath(files))
+
+	return files
+}
+
+type filesByPath entities.Files
+
+func (files filesByPath) Len() int {
+	return len(files)
+}
+
+func (files filesByPath) Swap(i, j int) {
+	files[i], files[j] = files[j], files[i]
+}
+
+func (files filesByPath) Less(i, j int) bool {
+	return files[i].Path() < files[j].Path()
+}
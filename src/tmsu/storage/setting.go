@@ -18,12 +18,40 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package storage
 
 import (
+	"fmt"
+	"sort"
+	"tmsu/common/fingerprint"
 	"tmsu/entities"
 )
 
-// The complete set of settings.
+type settingDefinition struct {
+	name         string
+	defaultValue string
+	validate     func(value string) error
+}
+
+// The settings that are recognised, along with their default values.
+var settingDefinitions = []settingDefinition{
+	{"fingerprintAlgorithm", "dynamic:SHA256", validateFingerprintAlgorithm},
+	{"journalMode", "delete", validateJournalMode},
+}
+
+// The complete set of settings, including those that have their default value.
 func (storage *Storage) Settings() (entities.Settings, error) {
-	return storage.Db.Settings()
+	settings, err := storage.Db.Settings()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, definition := range settingDefinitions {
+		if !containsSetting(settings, definition.name) {
+			settings = append(settings, &entities.Setting{definition.name, definition.defaultValue})
+		}
+	}
+
+	sort.Sort(settings)
+
+	return settings, nil
 }
 
 // Retrievs the specified setting.
@@ -35,11 +63,63 @@ func (storage *Storage) Setting(name string) (*entities.Setting, error) {
 
 	// defaults
 	if setting == nil {
-		switch name {
-		case "fingerprintAlgorithm":
-			return &entities.Setting{name, "dynamic:SHA256"}, nil
+		if definition := lookupSettingDefinition(name); definition != nil {
+			return &entities.Setting{name, definition.defaultValue}, nil
 		}
 	}
 
 	return setting, nil
 }
+
+// Updates the specified setting.
+func (storage *Storage) UpdateSetting(name, value string) (*entities.Setting, error) {
+	definition := lookupSettingDefinition(name)
+	if definition == nil {
+		return nil, fmt.Errorf("no such setting '%v'.", name)
+	}
+
+	if err := definition.validate(value); err != nil {
+		return nil, err
+	}
+
+	return storage.Db.UpdateSetting(name, value)
+}
+
+// unexported
+
+func lookupSettingDefinition(name string) *settingDefinition {
+	for index := range settingDefinitions {
+		if settingDefinitions[index].name == name {
+			return &settingDefinitions[index]
+		}
+	}
+
+	return nil
+}
+
+func containsSetting(settings entities.Settings, name string) bool {
+	for _, setting := range settings {
+		if setting.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateFingerprintAlgorithm(value string) error {
+	if !fingerprint.IsSupported(value) {
+		return fmt.Errorf("unsupported fingerprint algorithm '%v'.", value)
+	}
+
+	return nil
+}
+
+func validateJournalMode(value string) error {
+	switch value {
+	case "delete", "wal":
+		return nil
+	}
+
+	return fmt.Errorf("unsupported journal mode '%v': use 'delete' or 'wal'.", value)
+}
@@ -24,6 +24,13 @@ import (
 
 type Storage struct {
 	Db *database.Database
+
+	// The directory that paths are stored relative to, if any.
+	RootPath string
+
+	// The number of files each tag is applied to, by tag ID, as used to plan
+	// queries. Entries are removed whenever file tags change.
+	tagFileCounts map[uint]uint
 }
 
 func Open() (*Storage, error) {
@@ -32,7 +39,7 @@ func Open() (*Storage, error) {
 		return nil, fmt.Errorf("could not open database: %v", err)
 	}
 
-	return &Storage{db}, nil
+	return &Storage{db, database.LocalRoot(database.Path), make(map[uint]uint)}, nil
 }
 
 func OpenAt(path string) (*Storage, error) {
@@ -41,13 +48,24 @@ func OpenAt(path string) (*Storage, error) {
 		return nil, fmt.Errorf("could not open database at '%v': %v", path, err)
 	}
 
-	return &Storage{db}, nil
+	return &Storage{db, database.LocalRoot(path), make(map[uint]uint)}, nil
+}
+
+func (storage *Storage) Begin() error {
+	return storage.Db.Begin()
 }
 
 func (storage *Storage) Commit() error {
 	return storage.Db.Commit()
 }
 
+func (storage *Storage) Rollback() error {
+	// the cached counts may include changes that are now discarded
+	storage.clearTagFileCounts()
+
+	return storage.Db.Rollback()
+}
+
 func (storage *Storage) Close() error {
 	err := storage.Db.Close()
 	if err != nil {
@@ -20,8 +20,11 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"tmsu/entities"
 	"unicode"
+	"unicode/utf8"
 )
 
 // The number of tags in the database.
@@ -63,13 +66,30 @@ func (storage *Storage) AddTag(name string) (*entities.Tag, error) {
 	return storage.Db.InsertTag(name)
 }
 
-// Renames a tag.
+// Renames a tag. Saved queries that use the tag are updated accordingly.
 func (storage Storage) RenameTag(tagId uint, name string) (*entities.Tag, error) {
 	if err := validateTagName(name); err != nil {
 		return nil, err
 	}
 
-	return storage.Db.RenameTag(tagId, name)
+	tag, err := storage.Db.Tag(tagId)
+	if err != nil {
+		return nil, err
+	}
+	if tag == nil {
+		return nil, fmt.Errorf("no such tag #%v.", tagId)
+	}
+
+	renamedTag, err := storage.Db.RenameTag(tagId, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := storage.renameTagInQueries(tag.Name, name); err != nil {
+		return nil, fmt.Errorf("could not update saved queries: %v", err)
+	}
+
+	return renamedTag, nil
 }
 
 // Copies a tag.
@@ -83,20 +103,96 @@ func (storage Storage) CopyTag(sourceTagId uint, name string) (*entities.Tag, er
 		return nil, fmt.Errorf("could not create tag '%v': %v", name, err)
 	}
 
+	storage.clearTagFileCounts()
+
 	err = storage.Db.CopyFileTags(sourceTagId, tag.Id)
 	if err != nil {
 		return nil, fmt.Errorf("could not copy file tags for tag #%v to tag '%v': %v", sourceTagId, name, err)
 	}
 
+	err = storage.Db.CopyTagSettings(sourceTagId, tag.Id)
+	if err != nil {
+		return nil, fmt.Errorf("could not copy settings for tag #%v to tag '%v': %v", sourceTagId, name, err)
+	}
+
 	return tag, nil
 }
 
-// Deletes a tag.
+// Merges the source tag into the destination tag: files tagged with the source
+// tag are tagged with the destination tag instead and its implications and saved
+// queries are updated to use the destination tag. The source tag is then
+// deleted.
+func (storage Storage) MergeTag(sourceTagId, destTagId uint) error {
+	sourceTag, err := storage.Db.Tag(sourceTagId)
+	if err != nil {
+		return err
+	}
+	if sourceTag == nil {
+		return fmt.Errorf("no such tag #%v.", sourceTagId)
+	}
+
+	destTag, err := storage.Db.Tag(destTagId)
+	if err != nil {
+		return err
+	}
+	if destTag == nil {
+		return fmt.Errorf("no such tag #%v.", destTagId)
+	}
+
+	fileTags, err := storage.FileTagsByTagId(sourceTag.Id)
+	if err != nil {
+		return fmt.Errorf("could not retrieve files for tag '%v': %v", sourceTag.Name, err)
+	}
+
+	for _, fileTag := range fileTags {
+		if _, err := storage.AddFileTag(fileTag.FileId, destTag.Id, fileTag.ValueId); err != nil {
+			return fmt.Errorf("could not apply tag '%v' to file #%v: %v", destTag.Name, fileTag.FileId, err)
+		}
+	}
+
+	if err := storage.moveImplications(sourceTag.Id, destTag.Id); err != nil {
+		return fmt.Errorf("could not move implications of tag '%v' to tag '%v': %v", sourceTag.Name, destTag.Name, err)
+	}
+
+	if err := storage.renameTagInQueries(sourceTag.Name, destTag.Name); err != nil {
+		return fmt.Errorf("could not update saved queries: %v", err)
+	}
+
+	return storage.DeleteTag(sourceTag.Id)
+}
+
+// Deletes a tag. A tag cannot be deleted whilst saved queries use it.
 func (storage Storage) DeleteTag(tagId uint) error {
-	err := storage.DeleteFileTagsByTagId(tagId)
+	tag, err := storage.Db.Tag(tagId)
 	if err != nil {
 		return err
 	}
+	if tag == nil {
+		return fmt.Errorf("no such tag #%v.", tagId)
+	}
+
+	queries, err := storage.QueriesUsingTag(tag.Name)
+	if err != nil {
+		return fmt.Errorf("could not retrieve saved queries: %v", err)
+	}
+	if len(queries) > 0 {
+		return fmt.Errorf("tag '%v' is used by saved query '%v'.", tag.Name, queries[0].Text)
+	}
+
+	err = storage.DeleteFileTagsByTagId(tagId)
+	if err != nil {
+		return err
+	}
+
+	err = storage.Db.DeleteImplicationsByTagId(tagId)
+	if err != nil {
+		return fmt.Errorf("could not delete implications for tag '%v': %v", tagId, err)
+	}
+
+	err = storage.Db.DeleteTagSettings(tagId)
+	if err != nil {
+		return fmt.Errorf("could not delete settings for tag '%v': %v", tagId, err)
+	}
 
 	err = storage.Db.DeleteTag(tagId)
 	if err != nil {
@@ -111,8 +207,100 @@ func (storage Storage) TopTags(count uint) ([]entities.TagFileCount, error) {
 	return storage.Db.TopTags(count)
 }
 
+// Retrieves the names of up to three tags whose names are similar to the
+// specified name, most similar first. Used to suggest alternatives for a
+// mistyped tag name.
+func (storage Storage) SimilarTagNames(name string) ([]string, error) {
+	tags, err := storage.Db.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	maxDistance := utf8.RuneCountInString(name) / 3
+	if maxDistance > 2 {
+		maxDistance = 2
+	}
+
+	candidates := make(similarNames, 0, 10)
+	for _, tag := range tags {
+		distance := editDistance(strings.ToLower(name), strings.ToLower(tag.Name))
+		if distance <= maxDistance && tag.Name != name {
+			candidates = append(candidates, similarName{tag.Name, distance})
+		}
+	}
+
+	sort.Stable(candidates)
+
+	names := make([]string, 0, 3)
+	for index := 0; index < len(candidates) && index < 3; index++ {
+		names = append(names, candidates[index].name)
+	}
+
+	return names, nil
+}
+
 // unexported
 
+type similarName struct {
+	name     string
+	distance int
+}
+
+type similarNames []similarName
+
+func (names similarNames) Len() int {
+	return len(names)
+}
+
+func (names similarNames) Swap(i, j int) {
+	names[i], names[j] = names[j], names[i]
+}
+
+func (names similarNames) Less(i, j int) bool {
+	return names[i].distance < names[j].distance
+}
+
+// The Levenshtein distance between two strings: the number of single character
+// insertions, deletions or substitutions that turn one into the other.
+func editDistance(a, b string) int {
+	aRunes := []rune(a)
+	bRunes := []rune(b)
+
+	previous := make([]int, len(bRunes)+1)
+	current := make([]int, len(bRunes)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(aRunes); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(bRunes); j++ {
+			cost := 1
+			if aRunes[i-1] == bRunes[j-1] {
+				cost = 0
+			}
+
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(bRunes)]
+}
+
+func min(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+
+	return result
+}
+
 var validTagChars = []*unicode.RangeTable{unicode.Letter, unicode.Number, unicode.Punct, unicode.Symbol}
 
 func validateTagName(tagName string) error {
@@ -129,14 +317,28 @@ func validateTagName(tagName string) error {
 		return errors.New("tag name cannot start with a minus: '-'.") // used in query language
 	}
 
+	if tagName[0] == '@' {
+		return errors.New("tag name cannot start with an at sign: '@'.") // used for named queries
+	}
+
+	if tagName[0] == '\\' {
+		return errors.New("tag name cannot start with a backslash: '\\'.") // used in query language
+	}
+
+	if strings.Contains(tagName, "..") {
+		return errors.New("tag names cannot contain '..'.") // used in query language
+	}
+
 	for _, ch := range tagName {
 		switch ch {
 		case '(', ')':
 			return errors.New("tag names cannot contain parentheses: '(' or ')'.") // used in query language
 		case ',':
 			return errors.New("tag names cannot contain comma: ','.") // reserved for tag delimiter
-		case '=', '<', '>':
-			return errors.New("tag names cannot contain a comparison operator: '=', '<' or '>'.") // reserved for tag values
+		case '*', '?':
+			return errors.New("tag names cannot contain a wildcard: '*' or '?'.") // used in query language
+		case '=', '<', '>', '~':
+			return errors.New("tag names cannot contain a comparison operator: '=', '<', '>' or '~'.") // reserved for tag values
 		case ' ', '\t':
 			return errors.New("tag names cannot contain space or tab.") // used as tag delimiter
 		case '/':
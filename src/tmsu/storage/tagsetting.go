@@ -0,0 +1,159 @@
+/*
+Copyright 2011-2014 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"tmsu/entities"
+	"tmsu/query"
+)
+
+// The tag settings that are recognised, along with their default values.
+var tagSettingDefinitions = []settingDefinition{
+	{"type", "auto", validateValueType},
+}
+
+// The complete set of settings for a tag, including those that have their
+// default value.
+func (storage *Storage) TagSettings(tagId uint) (entities.Settings, error) {
+	settings, err := storage.Db.TagSettings(tagId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, definition := range tagSettingDefinitions {
+		if !containsSetting(settings, definition.name) {
+			settings = append(settings, &entities.Setting{definition.name, definition.defaultValue})
+		}
+	}
+
+	sort.Sort(settings)
+
+	return settings, nil
+}
+
+// Retrieves the specified setting of a tag.
+func (storage *Storage) TagSetting(tagId uint, name string) (*entities.Setting, error) {
+	setting, err := storage.Db.TagSetting(tagId, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// defaults
+	if setting == nil {
+		if definition := lookupTagSettingDefinition(name); definition != nil {
+			return &entities.Setting{name, definition.defaultValue}, nil
+		}
+	}
+
+	return setting, nil
+}
+
+// Updates the specified setting of a tag. The value type of a tag can only be
+// changed if the values it is already applied with are of the new type.
+func (storage *Storage) UpdateTagSetting(tagId uint, name, value string) (*entities.Setting, error) {
+	definition := lookupTagSettingDefinition(name)
+	if definition == nil {
+		return nil, fmt.Errorf("no such tag setting '%v'.", name)
+	}
+
+	if err := definition.validate(value); err != nil {
+		return nil, err
+	}
+
+	if name == "type" {
+		if err := storage.checkValuesOfType(tagId, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return storage.Db.UpdateTagSetting(tagId, name, value)
+}
+
+// Retrieves the value types that have been declared for tags, keyed by tag name.
+func (storage *Storage) ValueTypes() (map[string]string, error) {
+	return storage.Db.TagSettingsByName("type")
+}
+
+// unexported
+
+func lookupTagSettingDefinition(name string) *settingDefinition {
+	for index := range tagSettingDefinitions {
+		if tagSettingDefinitions[index].name == name {
+			return &tagSettingDefinitions[index]
+		}
+	}
+
+	return nil
+}
+
+func validateValueType(value string) error {
+	if !query.IsValueType(value) {
+		return fmt.Errorf("unsupported value type '%v': use 'auto', 'int', 'decimal', 'date' or 'text'.", value)
+	}
+
+	return nil
+}
+
+// Checks that the values a tag is applied with are all of the specified type.
+func (storage *Storage) checkValuesOfType(tagId uint, valueType string) error {
+	values, err := storage.Db.ValuesByTagId(tagId)
+	if err != nil {
+		return fmt.Errorf("could not retrieve values for tag #%v: %v", tagId, err)
+	}
+
+	for _, value := range values {
+		if err := query.ValidateValue(valueType, value.Name); err != nil {
+			return fmt.Errorf("files are already tagged with value '%v': %v", value.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Checks that a value is of the type declared for the tag it is to be applied
+// with.
+func (storage *Storage) checkTagValue(tagId, valueId uint) error {
+	setting, err := storage.Db.TagSetting(tagId, "type")
+	if err != nil {
+		return fmt.Errorf("could not retrieve value type of tag #%v: %v", tagId, err)
+	}
+	if setting == nil {
+		return nil
+	}
+
+	value, err := storage.Db.Value(valueId)
+	if err != nil {
+		return fmt.Errorf("could not retrieve value #%v: %v", valueId, err)
+	}
+	if value == nil {
+		return fmt.Errorf("no such value #%v.", valueId)
+	}
+
+	if err := query.ValidateValue(setting.Value, value.Name); err != nil {
+		tag, err2 := storage.Db.Tag(tagId)
+		if err2 != nil {
+			return err2
+		}
+
+		return fmt.Errorf("tag '%v' has %v values: %v", tag.Name, setting.Value, err)
+	}
+
+	return nil
+}
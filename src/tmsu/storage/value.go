@@ -88,7 +88,7 @@ func (storage *Storage) DeleteUnusedValues() error {
 
 // unexported
 
-var validValueChars = []*unicode.RangeTable{unicode.Letter, unicode.Number, unicode.Punct, unicode.Symbol}
+var validValueChars = []*unicode.RangeTable{unicode.Letter, unicode.Number, unicode.Punct, unicode.Symbol, unicode.Zs}
 
 func validateValueName(valueName string) error {
 	switch valueName {
@@ -100,16 +100,12 @@ func validateValueName(valueName string) error {
 		return errors.New("tag value cannot be a logical operator: 'and', 'or' or 'not'.") // used in query language
 	}
 
+	// values containing the query language's special characters can be quoted in queries
+
 	for _, ch := range valueName {
 		switch ch {
-		case '(', ')':
-			return errors.New("tag value cannot contain parentheses: '(' or ')'.") // used in query language
 		case ',':
 			return errors.New("tag value cannot contain comma: ','.") // reserved for tag delimiter
-		case '=', '<', '>':
-			return errors.New("tag value cannot contain a comparison operator: '=', '<' or '>'.") // reserved for tag values
-		case ' ', '\t':
-			return errors.New("tag value cannot contain space or tab.") // used as tag delimiter
 		case '/':
 			return errors.New("tag value cannot contain slash: '/'.") // cannot be used in the VFS
 		}
@@ -26,12 +26,14 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"tmsu/common/log"
 	"tmsu/entities"
 	"tmsu/query"
 	"tmsu/storage"
+	"tmsu/storage/database"
 )
 
 const tagsDir = "tags"
@@ -52,12 +54,53 @@ match the query:
     $ ls
     cheese and (tomato or mushroom)  cheese and wine 
 
+Values containing spaces or other special characters can be quoted:
+
+    $ ls 'title="Blue Train"'
+    bluetrain.mp3.21
+
 Query directories are saved automatically and can be removed with ` + "`rmdir`."
 
+// The interval at which the database is checked for changes made by other
+// processes, e.g. the 'tag' command.
+const databasePollInterval = time.Second
+
 type FuseVfs struct {
-	store     *storage.Storage
-	mountPath string
-	server    *fuse.Server
+	store        *storage.Storage
+	databasePath string
+	mountPath    string
+	server       *fuse.Server
+	pathFs       *pathfs.PathNodeFs
+	requestLock  *sync.Mutex
+	lookedUp     *lookedUpPaths
+}
+
+// The paths looked up since the database last changed, which are those the
+// kernel may hold cached entries for.
+type lookedUpPaths struct {
+	lock  sync.Mutex
+	paths map[string]bool
+}
+
+func (lookedUp *lookedUpPaths) add(path string) {
+	lookedUp.lock.Lock()
+	defer lookedUp.lock.Unlock()
+
+	lookedUp.paths[path] = true
+}
+
+// Retrieves the paths looked up and begins tracking afresh.
+func (lookedUp *lookedUpPaths) reset() []string {
+	lookedUp.lock.Lock()
+	defer lookedUp.lock.Unlock()
+
+	paths := make([]string, 0, len(lookedUp.paths))
+	for path := range lookedUp.paths {
+		paths = append(paths, path)
+	}
+	lookedUp.paths = make(map[string]bool)
+
+	return paths
 }
 
 func MountVfs(databasePath string, mountPath string, options []string) (*FuseVfs, error) {
@@ -77,8 +120,12 @@ func MountVfs(databasePath string, mountPath string, options []string) (*FuseVfs
 	}
 
 	fuseVfs.store = store
+	fuseVfs.databasePath = databasePath
 	fuseVfs.mountPath = mountPath
 	fuseVfs.server = server
+	fuseVfs.pathFs = pathFs
+	fuseVfs.requestLock = &sync.Mutex{}
+	fuseVfs.lookedUp = &lookedUpPaths{paths: make(map[string]bool)}
 
 	return &fuseVfs, nil
 }
@@ -88,7 +135,12 @@ func (vfs FuseVfs) Unmount() {
 }
 
 func (vfs FuseVfs) Serve() {
+	done := make(chan bool)
+	go vfs.watchDatabase(done)
+
 	vfs.server.Serve()
+
+	close(done)
 }
 
 func (vfs FuseVfs) SetDebug(debug bool) {
@@ -127,6 +179,11 @@ func (vfs FuseVfs) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse
 	log.Infof(2, "BEGIN GetAttr(%v)", name)
 	defer log.Infof(2, "END GetAttr(%v)", name)
 
+	vfs.lookedUp.add(name)
+
+	vfs.beginRequest()
+	defer vfs.endRequest()
+
 	switch name {
 	case "":
 		fallthrough
@@ -173,6 +230,9 @@ func (vfs FuseVfs) Mkdir(name string, mode uint32, context *fuse.Context) fuse.S
 	log.Infof(2, "BEGIN Mkdir(%v)", name)
 	defer log.Infof(2, "END Mkdir(%v)", name)
 
+	vfs.beginRequest()
+	defer vfs.endRequest()
+
 	path := vfs.splitPath(name)
 
 	if len(path) != 2 {
@@ -183,17 +243,24 @@ func (vfs FuseVfs) Mkdir(name string, mode uint32, context *fuse.Context) fuse.S
 	case tagsDir:
 		name := path[1]
 
+		if status := vfs.beginWrite(); status != fuse.OK {
+			return status
+		}
+
 		if _, err := vfs.store.AddTag(name); err != nil {
 			log.Fatalf("could not create tag '%v': %v", name, err)
 		}
 
-		if err := vfs.store.Commit(); err != nil {
-			log.Fatalf("could not commit transaction: %v", err)
+		return vfs.commitRequest()
+	case queriesDir:
+		queryText := path[1]
+
+		if status, err := vfs.checkQuery(queryText); status != fuse.OK {
+			log.Warnf("invalid query '%v': %v", queryText, vfs.suggestTags(err))
+			return status
 		}
 
-		return fuse.OK
-	case queriesDir:
-		return fuse.EINVAL
+		return vfs.saveQuery(queryText)
 	}
 
 	return fuse.ENOSYS
@@ -231,6 +298,11 @@ func (vfs FuseVfs) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry,
 	log.Infof(2, "BEGIN OpenDir(%v)", name)
 	defer log.Infof(2, "END OpenDir(%v)", name)
 
+	vfs.lookedUp.add(name)
+
+	vfs.beginRequest()
+	defer vfs.endRequest()
+
 	switch name {
 	case "":
 		return vfs.topDirectories()
@@ -255,6 +327,9 @@ func (vfs FuseVfs) Readlink(name string, context *fuse.Context) (string, fuse.St
 	log.Infof(2, "BEGIN Readlink(%v)", name)
 	defer log.Infof(2, "END Readlink(%v)", name)
 
+	vfs.beginRequest()
+	defer vfs.endRequest()
+
 	path := vfs.splitPath(name)
 	switch path[0] {
 	case tagsDir, queriesDir:
@@ -275,6 +350,9 @@ func (vfs FuseVfs) Rename(oldName string, newName string, context *fuse.Context)
 	log.Infof(2, "BEGIN Rename(%v, %v)", oldName, newName)
 	defer log.Infof(2, "END Rename(%v, %v)", oldName, newName)
 
+	vfs.beginRequest()
+	defer vfs.endRequest()
+
 	oldPath := vfs.splitPath(oldName)
 	newPath := vfs.splitPath(newName)
 
@@ -289,6 +367,10 @@ func (vfs FuseVfs) Rename(oldName string, newName string, context *fuse.Context)
 	oldTagName := oldPath[1]
 	newTagName := newPath[1]
 
+	if status := vfs.beginWrite(); status != fuse.OK {
+		return status
+	}
+
 	tag, err := vfs.store.TagByName(oldTagName)
 	if err != nil {
 		log.Fatalf("could not retrieve tag '%v': %v", oldTagName, err)
@@ -301,17 +383,16 @@ func (vfs FuseVfs) Rename(oldName string, newName string, context *fuse.Context)
 		log.Fatalf("could not rename tag '%v' to '%v': %v", oldTagName, newTagName, err)
 	}
 
-	if err := vfs.store.Commit(); err != nil {
-		log.Fatalf("could not commit transaction: %v", err)
-	}
-
-	return fuse.OK
+	return vfs.commitRequest()
 }
 
 func (vfs FuseVfs) Rmdir(name string, context *fuse.Context) fuse.Status {
 	log.Infof(2, "BEGIN Rmdir(%v)", name)
 	defer log.Infof(2, "END Rmdir(%v)", name)
 
+	vfs.beginRequest()
+	defer vfs.endRequest()
+
 	path := vfs.splitPath(name)
 
 	switch path[0] {
@@ -322,6 +403,11 @@ func (vfs FuseVfs) Rmdir(name string, context *fuse.Context) fuse.Status {
 		}
 
 		tagName := path[1]
+
+		if status := vfs.beginWrite(); status != fuse.OK {
+			return status
+		}
+
 		tag, err := vfs.store.TagByName(tagName)
 		if err != nil {
 			log.Fatalf("could not retrieve tag '%v': %v", tagName, err)
@@ -338,15 +424,13 @@ func (vfs FuseVfs) Rmdir(name string, context *fuse.Context) fuse.Status {
 			return fuse.Status(syscall.ENOTEMPTY)
 		}
 
+		// a tag used by a saved query cannot be deleted
 		if err := vfs.store.DeleteTag(tag.Id); err != nil {
-			log.Fatalf("could not delete tag '%v': %v", tagName, err)
-		}
-
-		if err := vfs.store.Commit(); err != nil {
-			log.Fatalf("could not commit transaction: %v", err)
+			log.Warnf("could not delete tag '%v': %v", tagName, err)
+			return fuse.EPERM
 		}
 
-		return fuse.OK
+		return vfs.commitRequest()
 	case queriesDir:
 		if len(path) != 2 {
 			// can only remove top-level queries directories
@@ -355,15 +439,15 @@ func (vfs FuseVfs) Rmdir(name string, context *fuse.Context) fuse.Status {
 
 		text := path[1]
 
-		if err := vfs.store.DeleteQuery(text); err != nil {
-			log.Fatalf("could not remove tag '%v': %v", name, err)
+		if status := vfs.beginWrite(); status != fuse.OK {
+			return status
 		}
 
-		if err := vfs.store.Commit(); err != nil {
-			log.Fatalf("could not commit transaction: %v", err)
+		if err := vfs.store.DeleteQuery(text); err != nil {
+			log.Fatalf("could not remove tag '%v': %v", name, err)
 		}
 
-		return fuse.OK
+		return vfs.commitRequest()
 	}
 
 	return fuse.ENOSYS
@@ -405,12 +489,19 @@ func (vfs FuseVfs) Unlink(name string, context *fuse.Context) fuse.Status {
 	log.Infof(2, "BEGIN Unlink(%v)", name)
 	defer log.Infof(2, "END Unlink(%v)", name)
 
+	vfs.beginRequest()
+	defer vfs.endRequest()
+
 	fileId := vfs.parseFileId(name)
 	if fileId == 0 {
 		// can only unlink file symbolic links
 		return fuse.EPERM
 	}
 
+	if status := vfs.beginWrite(); status != fuse.OK {
+		return status
+	}
+
 	file, err := vfs.store.File(fileId)
 	if err != nil {
 		log.Fatal("could not retrieve file '%v': %v", fileId, err)
@@ -438,11 +529,7 @@ func (vfs FuseVfs) Unlink(name string, context *fuse.Context) fuse.Status {
 			log.Fatal(err)
 		}
 
-		if err := vfs.store.Commit(); err != nil {
-			log.Fatalf("could not commit transaction: %v", err)
-		}
-
-		return fuse.OK
+		return vfs.commitRequest()
 	case queriesDir:
 		return fuse.EPERM
 	}
@@ -456,6 +543,141 @@ func (vfs FuseVfs) Utimens(name string, Atime *time.Time, Mtime *time.Time, cont
 
 // non-exported
 
+// Serializes access to the database. Reads made by the request take place in a
+// transaction of their own so that changes committed by other processes are seen.
+func (vfs FuseVfs) beginRequest() {
+	vfs.requestLock.Lock()
+}
+
+// Begins a transaction for a request that makes changes, waiting for any other
+// process that is making changes to finish.
+func (vfs FuseVfs) beginWrite() fuse.Status {
+	vfs.store.Rollback()
+
+	if err := vfs.store.Begin(); err != nil {
+		log.Warnf("could not begin transaction: %v", err)
+		return fuse.Status(syscall.EBUSY)
+	}
+
+	return fuse.OK
+}
+
+// Commits the changes made by the request.
+func (vfs FuseVfs) commitRequest() fuse.Status {
+	if err := vfs.store.Commit(); err != nil {
+		log.Warnf("could not commit transaction: %v", err)
+		return fuse.Status(syscall.EBUSY)
+	}
+
+	return fuse.OK
+}
+
+// Ends the request's transaction, abandoning any changes that were not committed.
+func (vfs FuseVfs) endRequest() {
+	if err := vfs.store.Rollback(); err != nil {
+		log.Warnf("could not end transaction: %v", err)
+	}
+
+	vfs.requestLock.Unlock()
+}
+
+// Polls the database for changes, invalidating the kernel's cached entries
+// whenever it changes, until done is closed. The top-level tag and query
+// directories are invalidated along with every path looked up since the last
+// change, such as nested tag directories and the file links within them.
+func (vfs FuseVfs) watchDatabase(done chan bool) {
+	signature := vfs.databaseSignature()
+	tagNames, queryTexts := vfs.entryNames()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(databasePollInterval):
+		}
+
+		newSignature := vfs.databaseSignature()
+		if newSignature == signature {
+			continue
+		}
+		signature = newSignature
+
+		log.Infof(2, "database changed: invalidating cached entries.")
+
+		newTagNames, newQueryTexts := vfs.entryNames()
+
+		vfs.pathFs.Notify("")
+		vfs.pathFs.Notify(tagsDir)
+		vfs.pathFs.Notify(queriesDir)
+
+		// entries are invalidated both for what was and what now is
+		for _, tagName := range append(tagNames, newTagNames...) {
+			vfs.pathFs.EntryNotify(tagsDir, tagName)
+		}
+		for _, queryText := range append(queryTexts, newQueryTexts...) {
+			vfs.pathFs.EntryNotify(queriesDir, queryText)
+		}
+		for _, path := range vfs.lookedUp.reset() {
+			if path == "" {
+				continue
+			}
+
+			dir, name := "", path
+			if index := strings.LastIndex(path, string(filepath.Separator)); index != -1 {
+				dir, name = path[:index], path[index+1:]
+			}
+
+			vfs.pathFs.EntryNotify(dir, name)
+			vfs.pathFs.Notify(path)
+		}
+
+		tagNames, queryTexts = newTagNames, newQueryTexts
+	}
+}
+
+// Identifies changes to the database, including its write-ahead log.
+func (vfs FuseVfs) databaseSignature() string {
+	signature := ""
+
+	for _, path := range []string{vfs.databasePath, vfs.databasePath + "-wal"} {
+		if stat, err := os.Stat(path); err == nil {
+			signature += fmt.Sprintf("%v:%v;", stat.ModTime().UnixNano(), stat.Size())
+		}
+	}
+
+	return signature
+}
+
+// The names of the top-level tag and query directories.
+func (vfs FuseVfs) entryNames() ([]string, []string) {
+	vfs.beginRequest()
+	defer vfs.endRequest()
+
+	tags, err := vfs.store.Tags()
+	if err != nil {
+		log.Warnf("could not retrieve tags: %v", err)
+		return nil, nil
+	}
+
+	tagNames := make([]string, len(tags))
+	for index, tag := range tags {
+		tagNames[index] = tag.Name
+	}
+
+	queries, err := vfs.store.Queries()
+	if err != nil {
+		log.Warnf("could not retrieve queries: %v", err)
+		return tagNames, nil
+	}
+
+	queryTexts := make([]string, len(queries))
+	for index, query := range queries {
+		queryTexts[index] = query.Text
+	}
+
+	return tagNames, queryTexts
+}
+
 func (vfs FuseVfs) splitPath(path string) []string {
 	return strings.Split(path, string(filepath.Separator))
 }
@@ -600,24 +822,13 @@ func (vfs FuseVfs) getQueryEntryAttr(path []string) (*fuse.Attr, fuse.Status) {
 		return nil, fuse.ENOENT
 	}
 
-	expression, err := query.Parse(queryText)
-	if err != nil {
+	if status, err := vfs.checkQuery(queryText); status != fuse.OK {
+		log.Infof(2, "invalid query '%v': %v", queryText, err)
 		return nil, fuse.ENOENT
 	}
 
-	tagNames := query.TagNames(expression)
-	tags, err := vfs.store.TagsByNames(tagNames)
-	for _, tagName := range tagNames {
-		if !containsTag(tags, tagName) {
-			return nil, fuse.ENOENT
-		}
-	}
-
-	_, _ = vfs.store.AddQuery(queryText)
-
-	if err := vfs.store.Commit(); err != nil {
-		log.Fatalf("could not commit transaction: %v", err)
-	}
+	// the query works regardless of whether it could be saved
+	vfs.saveQuery(queryText)
 
 	now := time.Now()
 	return &fuse.Attr{Mode: fuse.S_IFDIR | 0755, Nlink: 2, Size: uint64(0), Mtime: uint64(now.Unix()), Mtimensec: uint32(now.Nanosecond())}, fuse.OK
@@ -651,7 +862,14 @@ func (vfs FuseVfs) openTaggedEntryDir(path []string) ([]fuse.DirEntry, fuse.Stat
 	defer log.Infof(2, "END openTaggedEntryDir(%v)", path)
 
 	expression := query.HasAll(path)
-	files, err := vfs.store.QueryFiles(expression)
+
+	fileEntries := make([]fuse.DirEntry, 0, 10)
+	fileIds := make([]uint, 0, 10)
+	err := vfs.store.ForEachQueryFile(expression, database.FileSelection{}, func(file *entities.File) error {
+		fileEntries = append(fileEntries, fuse.DirEntry{Name: vfs.getLinkName(file), Mode: fuse.S_IFLNK})
+		fileIds = append(fileIds, file.Id)
+		return nil
+	})
 	if err != nil {
 		log.Fatalf("could not query files: %v", err)
 	}
@@ -662,10 +880,10 @@ func (vfs FuseVfs) openTaggedEntryDir(path []string) ([]fuse.DirEntry, fuse.Stat
 	}
 
 	furtherTagNames := make([]string, 0, 10)
-	for _, file := range files {
-		fileTags, err := vfs.store.FileTagsByFileId(file.Id)
+	for _, fileId := range fileIds {
+		fileTags, err := vfs.store.FileTagsByFileId(fileId)
 		if err != nil {
-			log.Fatalf("could not retrieve file-tags for file '%v': %v", file.Id, err)
+			log.Fatalf("could not retrieve file-tags for file '%v': %v", fileId, err)
 		}
 
 		tagIds := make([]uint, len(fileTags))
@@ -688,14 +906,11 @@ func (vfs FuseVfs) openTaggedEntryDir(path []string) ([]fuse.DirEntry, fuse.Stat
 		}
 	}
 
-	entries := make([]fuse.DirEntry, 0, len(files)+len(furtherTagNames))
+	entries := make([]fuse.DirEntry, 0, len(fileEntries)+len(furtherTagNames))
 	for _, tagName := range furtherTagNames {
 		entries = append(entries, fuse.DirEntry{Name: tagName, Mode: fuse.S_IFDIR | 0755})
 	}
-	for _, file := range files {
-		linkName := vfs.getLinkName(file)
-		entries = append(entries, fuse.DirEntry{Name: linkName, Mode: fuse.S_IFLNK})
-	}
+	entries = append(entries, fileEntries...)
 
 	return entries, fuse.OK
 }
@@ -706,30 +921,21 @@ func (vfs FuseVfs) openQueryEntryDir(path []string) ([]fuse.DirEntry, fuse.Statu
 
 	queryText := path[0]
 
-	expression, err := query.Parse(queryText)
-	if err != nil {
+	expression, status, err := vfs.parseQuery(queryText)
+	if status != fuse.OK {
+		log.Infof(2, "invalid query '%v': %v", queryText, err)
 		return nil, fuse.ENOENT
 	}
 
-	tagNames := query.TagNames(expression)
-	tags, err := vfs.store.TagsByNames(tagNames)
-	for _, tagName := range tagNames {
-		if !containsTag(tags, tagName) {
-			return nil, fuse.ENOENT
-		}
-	}
-
-	files, err := vfs.store.QueryFiles(expression)
+	entries := make([]fuse.DirEntry, 0, 10)
+	err = vfs.store.ForEachQueryFile(expression, database.FileSelection{}, func(file *entities.File) error {
+		entries = append(entries, fuse.DirEntry{Name: vfs.getLinkName(file), Mode: fuse.S_IFLNK})
+		return nil
+	})
 	if err != nil {
 		log.Fatalf("could not query files: %v", err)
 	}
 
-	entries := make([]fuse.DirEntry, 0, len(files))
-	for _, file := range files {
-		linkName := vfs.getLinkName(file)
-		entries = append(entries, fuse.DirEntry{Name: linkName, Mode: fuse.S_IFLNK})
-	}
-
 	return entries, fuse.OK
 }
 
@@ -783,7 +989,100 @@ func (vfs FuseVfs) tagNamesToIds(tagNames []string) ([]uint, error) {
 	return tagIds, nil
 }
 
-func (vfs FuseVfs) saveQuery(query string) {
+// Checks that the query is valid and refers only to tags that exist.
+func (vfs FuseVfs) checkQuery(queryText string) (fuse.Status, error) {
+	_, status, err := vfs.parseQuery(queryText)
+	return status, err
+}
+
+// Parses the query, expanding any references to named queries, and checks that
+// it refers only to tags that exist. If the query is not valid the returned
+// error describes the problem.
+func (vfs FuseVfs) parseQuery(queryText string) (query.Expression, fuse.Status, error) {
+	expression, err := query.Parse(queryText)
+	if err != nil {
+		return nil, fuse.EINVAL, err
+	}
+
+	expression, err = vfs.store.ExpandMacros(expression)
+	if err != nil {
+		return nil, fuse.ENOENT, fmt.Errorf("could not expand query: %v", err)
+	}
+
+	tagNames := query.TagNames(expression)
+	tags, err := vfs.store.TagsByNames(tagNames)
+	if err != nil {
+		log.Fatalf("could not retrieve tags: %v", err)
+	}
+
+	for _, tagName := range tagNames {
+		if !containsTag(tags, tagName) {
+			return nil, fuse.ENOENT, noSuchTagError{tagName}
+		}
+	}
+
+	return expression, fuse.OK, nil
+}
+
+// The error for a query that refers to a tag that does not exist.
+type noSuchTagError struct {
+	tagName string
+}
+
+func (err noSuchTagError) Error() string {
+	return fmt.Sprintf("no such tag '%v'.", err.tagName)
+}
+
+// Adds the names of similarly named tags to the error for a query that refers to
+// a tag that does not exist. As finding these is relatively slow it is left until
+// the error is reported rather than done for every failed lookup.
+func (vfs FuseVfs) suggestTags(err error) error {
+	noSuchTag, ok := err.(noSuchTagError)
+	if !ok {
+		return err
+	}
+
+	similarNames, err := vfs.store.SimilarTagNames(noSuchTag.tagName)
+	if err != nil {
+		log.Fatalf("could not retrieve similar tags: %v", err)
+	}
+
+	if len(similarNames) == 0 {
+		return noSuchTag
+	}
+
+	return fmt.Errorf("no such tag '%v': did you mean '%v'?", noSuchTag.tagName, strings.Join(similarNames, "', '"))
+}
+
+// Adds the query to the saved queries, if it is not already saved.
+func (vfs FuseVfs) saveQuery(queryText string) fuse.Status {
+	savedQuery, err := vfs.store.Query(queryText)
+	if err != nil {
+		log.Fatalf("could not retrieve query '%v': %v", queryText, err)
+	}
+	if savedQuery != nil {
+		return fuse.OK
+	}
+
+	if status := vfs.beginWrite(); status != fuse.OK {
+		return status
+	}
+
+	// another process may have saved it in the meantime
+	savedQuery, err = vfs.store.Query(queryText)
+	if err != nil {
+		log.Fatalf("could not retrieve query '%v': %v", queryText, err)
+	}
+	if savedQuery != nil {
+		return fuse.OK
+	}
+
+	if _, err := vfs.store.AddQuery(queryText, ""); err != nil {
+		log.Warnf("could not save query '%v': %v", queryText, err)
+		return fuse.EIO
+	}
+
+	return vfs.commitRequest()
 }
 
 func uitoa(ui uint) string {